@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/busoc/duplicate/pkg/duplicate"
+)
+
+func runCheck(args []string) error {
+	set := flag.NewFlagSet("check", flag.ExitOnError)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() == 0 {
+		return fmt.Errorf("check: configuration file expected")
+	}
+	c, err := duplicate.LoadConfig(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	problems := c.Check()
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if n := len(problems); n > 0 {
+		return fmt.Errorf("%s: %d problem(s) found", set.Arg(0), n)
+	}
+	fmt.Printf("%s: configuration ok\n", set.Arg(0))
+	return nil
+}
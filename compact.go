@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/busoc/duplicate/pkg/duplicate"
+)
+
+func runCompact(args []string) error {
+	set := flag.NewFlagSet("compact", flag.ExitOnError)
+	var (
+		older = set.Duration("o", 24*time.Hour, "minimum age of the archives to compact")
+		apids = set.String("a", "", "comma separated list of APIDs to keep")
+		gz    = set.Bool("z", false, "compress the compacted archives with gzip")
+		size  duplicate.Size
+	)
+	size.Set("512")
+	set.Var(&size, "s", "maximum size of the compacted archives (eg: 1GiB, in MB without unit)")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(set.Arg(0), "*.dat"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	var keep []string
+	if *apids != "" {
+		keep = strings.Split(*apids, ",")
+	}
+	var (
+		limit = size.In(1 << 20)
+		batch []string
+		total int64
+		group string
+	)
+	flush := func() error {
+		defer func() {
+			batch, total = batch[:0], 0
+		}()
+		if len(batch) == 0 || (len(batch) == 1 && len(keep) == 0 && !*gz) {
+			return nil
+		}
+		c, err := duplicate.CompactArchives(batch, keep, *gz)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d file(s) compacted, %d packet(s) kept, %d dropped, %d -> %d bytes\n", c.Target, c.Files, c.Kept, c.Dropped, c.Before, c.After)
+		return nil
+	}
+	for _, f := range files {
+		i, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		if time.Since(i.ModTime()) < *older {
+			continue
+		}
+		g := archiveGroup(f)
+		if g != group || (len(batch) > 0 && total+i.Size() > limit) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		group = g
+		batch = append(batch, f)
+		total += i.Size()
+	}
+	return flush()
+}
+
+func archiveGroup(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), ".dat")
+	for i := 0; i < 3; i++ {
+		x := strings.LastIndex(name, "_")
+		if x < 0 {
+			break
+		}
+		name = name[:x]
+	}
+	return name
+}
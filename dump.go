@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/busoc/duplicate/pkg/duplicate"
+)
+
+func runDump(args []string) error {
+	set := flag.NewFlagSet("dump", flag.ExitOnError)
+	var (
+		proto   = set.String("p", duplicate.DefaultProtocol, "protocol of the listener (udp, tcp or raw)")
+		nic     = set.String("i", "", "interface used to join the multicast group or to capture")
+		framing = set.String("f", "", "framing of the tcp stream")
+		hexa    = set.Bool("x", false, "print the hex dump of each packet")
+		count   = set.Int("n", 0, "stop after the given number of packets")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if set.NArg() == 0 {
+		return fmt.Errorf("dump: address expected")
+	}
+	l := duplicate.Listener{
+		Remote:  set.Arg(0),
+		Ifi:     *nic,
+		Proto:   *proto,
+		Framing: *framing,
+	}
+	r, err := l.Listen(duplicate.Certificate{})
+	if err != nil {
+		return err
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		r.Close()
+	}()
+	defer r.Close()
+
+	var (
+		w   = bufio.NewWriter(os.Stdout)
+		buf = make([]byte, duplicate.MaxPacketSize)
+	)
+	defer w.Flush()
+	for i := 0; *count <= 0 || i < *count; i++ {
+		n, addr, err := r.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if err != nil && !errors.Is(err, duplicate.ErrCorrupted) {
+			return err
+		}
+		dumpPacket(w, buf[:n], addr, time.Now(), *hexa)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ccsdsHeaderLen is the size of the primary header of the ccsds packets.
+const ccsdsHeaderLen = 6
+
+func dumpPacket(w io.Writer, xs []byte, addr net.Addr, when time.Time, hexa bool) {
+	src := "-"
+	if addr != nil {
+		src = addr.String()
+	}
+	fmt.Fprintf(w, "%s %s length %d", when.Format("15:04:05.000000"), src, len(xs))
+	if len(xs) >= ccsdsHeaderLen {
+		var (
+			apid = binary.BigEndian.Uint16(xs) & 0x07ff
+			seq  = binary.BigEndian.Uint16(xs[2:]) & 0x3fff
+			size = int(binary.BigEndian.Uint16(xs[4:])) + ccsdsHeaderLen + 1
+		)
+		fmt.Fprintf(w, ", apid %d, seq %d, size %d", apid, seq, size)
+		if size != len(xs) {
+			io.WriteString(w, " (mismatch)")
+		}
+	}
+	io.WriteString(w, "\n")
+	if hexa {
+		io.WriteString(w, hex.Dump(xs))
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/busoc/duplicate/pkg/duplicate"
+)
+
+const probeLen = 16
+
+func runHarness(args []string) error {
+	set := flag.NewFlagSet("harness", flag.ExitOnError)
+	var (
+		count = set.Int("n", 1000, "number of packets to generate")
+		size  = set.Int("s", 512, "size of the generated packets")
+		rate  = set.Int("r", 100, "number of packets generated per second")
+		wait  = set.Duration("w", time.Second, "time to wait for late packets")
+	)
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	c, err := duplicate.LoadConfig(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	t := c.Default()
+	if t.Proto == "tcp" && t.Framing != "ccsds" {
+		return fmt.Errorf("harness: tcp listener requires ccsds framing")
+	}
+	if *count <= 0 || *rate <= 0 {
+		return fmt.Errorf("harness: count and rate should be strictly positive")
+	}
+	if *size < probeLen {
+		*size = probeLen
+	}
+	ccsds := t.Framing == "ccsds"
+
+	var (
+		rs  []*receiver
+		wg  sync.WaitGroup
+		lag time.Duration
+	)
+	for _, r := range t.Routes {
+		if r.Proto != "" && r.Proto != duplicate.DefaultProtocol {
+			fmt.Printf("%s: skipped (%s route)\n", r.Addr, r.Proto)
+			continue
+		}
+		if r.Listen {
+			fmt.Printf("%s: skipped (listening route)\n", r.Addr)
+			continue
+		}
+		rc, err := receive(r.Addr, ccsds)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		rs = append(rs, rc)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rc.Run()
+		}()
+		if d := r.Delay.In(time.Millisecond) + r.Jitter.In(time.Millisecond); d > lag {
+			lag = d
+		}
+	}
+
+	// only the default tenant is fed by the harness, without the services of
+	// the process.
+	c.Tenants = nil
+	c.Admin, c.Recovery.File, c.PauseFile = "", "", ""
+	d := duplicate.New(c)
+	if err := d.Err(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(ctx)
+	}()
+	if err := generate(t, *count, *size, *rate, ccsds); err != nil {
+		cancel()
+		<-done
+		return err
+	}
+	time.Sleep(lag + *wait)
+	cancel()
+	if err := <-done; err != nil {
+		return err
+	}
+	for _, rc := range rs {
+		rc.Close()
+	}
+	wg.Wait()
+
+	for _, rc := range rs {
+		rc.Report(*count)
+	}
+	return nil
+}
+
+func generate(t duplicate.Tenant, count, size, rate int, ccsds bool) error {
+	proto := t.Proto
+	if proto == "" {
+		proto = duplicate.DefaultProtocol
+	}
+	w, err := net.Dial(proto, t.Remote)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	var (
+		every = time.Second / time.Duration(rate)
+		tick  = time.NewTicker(every)
+		buf   = make([]byte, size)
+		body  = buf
+	)
+	defer tick.Stop()
+	if ccsds {
+		buf = make([]byte, ccsdsHeaderLen+size)
+		body = buf[ccsdsHeaderLen:]
+		binary.BigEndian.PutUint16(buf[4:], uint16(size-1))
+	}
+	for i := 0; i < count; i++ {
+		<-tick.C
+		if ccsds {
+			binary.BigEndian.PutUint16(buf[2:], 0xC000|uint16(i&0x3FFF))
+		}
+		binary.BigEndian.PutUint64(body, uint64(i))
+		binary.BigEndian.PutUint64(body[8:], uint64(time.Now().UnixNano()))
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type receiver struct {
+	net.PacketConn
+	addr  string
+	ccsds bool
+
+	seen       map[uint64]struct{}
+	last       uint64
+	invalid    int
+	duplicated int
+	reordered  int
+	min        time.Duration
+	max        time.Duration
+	sum        time.Duration
+}
+
+func receive(addr string, ccsds bool) (*receiver, error) {
+	a, err := net.ResolveUDPAddr(duplicate.DefaultProtocol, addr)
+	if err != nil {
+		return nil, err
+	}
+	var c *net.UDPConn
+	if a.IP.IsMulticast() {
+		c, err = net.ListenMulticastUDP(duplicate.DefaultProtocol, nil, a)
+	} else {
+		c, err = net.ListenUDP(duplicate.DefaultProtocol, a)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := receiver{
+		PacketConn: c,
+		addr:       addr,
+		ccsds:      ccsds,
+		seen:       make(map[uint64]struct{}),
+	}
+	return &rc, nil
+}
+
+func (r *receiver) Run() {
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := r.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		r.check(buf[:n])
+	}
+}
+
+func (r *receiver) check(body []byte) {
+	now := time.Now()
+	if r.ccsds {
+		if len(body) < ccsdsHeaderLen {
+			r.invalid++
+			return
+		}
+		body = body[ccsdsHeaderLen:]
+	}
+	if len(body) < probeLen {
+		r.invalid++
+		return
+	}
+	seq := binary.BigEndian.Uint64(body)
+	if _, ok := r.seen[seq]; ok {
+		r.duplicated++
+		return
+	}
+	if len(r.seen) > 0 && seq < r.last {
+		r.reordered++
+	}
+	r.seen[seq], r.last = struct{}{}, seq
+
+	when := time.Unix(0, int64(binary.BigEndian.Uint64(body[8:])))
+	lat := now.Sub(when)
+	if len(r.seen) == 1 || lat < r.min {
+		r.min = lat
+	}
+	if lat > r.max {
+		r.max = lat
+	}
+	r.sum += lat
+}
+
+func (r *receiver) Report(count int) {
+	var (
+		recv = len(r.seen)
+		lost = count - recv
+		avg  time.Duration
+	)
+	if recv > 0 {
+		avg = r.sum / time.Duration(recv)
+	}
+	fmt.Printf("%s: received: %d, lost: %d (%.2f%%), duplicated: %d, reordered: %d, invalid: %d, latency: %s/%s/%s\n",
+		r.addr,
+		recv,
+		lost,
+		float64(lost)*100/float64(count),
+		r.duplicated,
+		r.reordered,
+		r.invalid,
+		r.min.Round(time.Microsecond),
+		avg.Round(time.Microsecond),
+		r.max.Round(time.Microsecond),
+	)
+}
@@ -0,0 +1,86 @@
+package duplicate
+
+import (
+	"net"
+	"strings"
+)
+
+type acl struct {
+	PacketReader
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	rejected *counter
+}
+
+func aclReader(r PacketReader, allow, deny []string, rejected *counter) (PacketReader, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return r, nil
+	}
+	a := acl{
+		PacketReader: r,
+		rejected:     rejected,
+	}
+	var err error
+	if a.allow, err = parseNetworks(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parseNetworks(deny); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (a *acl) ReadFrom(xs []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := a.PacketReader.ReadFrom(xs)
+		if err != nil || a.accept(addr) {
+			return n, addr, err
+		}
+		a.rejected.count(n)
+	}
+}
+
+func (a *acl) accept(addr net.Addr) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(vs []string) ([]*net.IPNet, error) {
+	var ns []*net.IPNet
+	for _, v := range vs {
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
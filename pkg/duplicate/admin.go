@@ -0,0 +1,224 @@
+package duplicate
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type admin struct {
+	relays  []*relay
+	monitor *monitor
+	start   time.Time
+	server  *http.Server
+	done    chan struct{}
+}
+
+func (a *admin) Serve(addr string) error {
+	s, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/routes", a.listRoutes)
+	mux.HandleFunc("/stats", a.showStats)
+	mux.HandleFunc("/drain", a.drainInput)
+	mux.HandleFunc("/pause/", a.pauseRoute)
+	a.server = &http.Server{Handler: mux}
+	a.done = make(chan struct{})
+	go func() {
+		defer close(a.done)
+		a.server.Serve(s)
+	}()
+	return nil
+}
+
+// Close closes the listener of the admin API and its connections.
+func (a *admin) Close() error {
+	err := a.server.Close()
+	<-a.done
+	return err
+}
+
+func (a *admin) listRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	type status struct {
+		Route
+		Tenant string `json:"tenant,omitempty"`
+		Paused bool   `json:"paused"`
+	}
+	var rs []status
+	for _, x := range a.relays {
+		for _, r := range x.routes {
+			rs = append(rs, status{Route: r.Route, Tenant: x.name, Paused: r.Paused()})
+		}
+	}
+	writeJSON(w, rs)
+}
+
+type routeStats struct {
+	Name     string        `json:"name"`
+	Sent     counter       `json:"sent"`
+	Dropped  counter       `json:"dropped"`
+	Overflow counter       `json:"overflow"`
+	Queue    int           `json:"queue"`
+	Filtered counter       `json:"filtered"`
+	Errors   uint64        `json:"errors"`
+	Paused   bool          `json:"paused"`
+	Beats    uint64        `json:"heartbeats,omitempty"`
+	Probes   uint64        `json:"latency-probes,omitempty"`
+	Latency  *summary      `json:"latency,omitempty"`
+	Clients  *clientStats  `json:"clients,omitempty"`
+	Remote   *probeStats   `json:"remote,omitempty"`
+	Members  []memberStats `json:"routes,omitempty"`
+}
+
+type tenantStats struct {
+	Name      string              `json:"name,omitempty"`
+	Received  counter             `json:"received"`
+	Rejected  counter             `json:"rejected"`
+	Discarded counter             `json:"discarded"`
+	Corrupted counter             `json:"corrupted"`
+	Sequence  map[uint16]sequence `json:"sequence,omitempty"`
+	Reorder   *resequenceStats    `json:"reorder,omitempty"`
+	FEC       *fecStats           `json:"fec,omitempty"`
+	Sources   []sourceStats       `json:"sources,omitempty"`
+	Archive   *archiveStats       `json:"archive,omitempty"`
+	Latency   []latencyStats      `json:"latency,omitempty"`
+	Routes    []routeStats        `json:"routes"`
+}
+
+func (a *admin) showStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	c := struct {
+		Uptime    string    `json:"uptime"`
+		Resources usage     `json:"resources"`
+		Buffers   poolStats `json:"buffers"`
+		tenantStats
+		Tenants []tenantStats `json:"tenants,omitempty"`
+	}{
+		Uptime:    time.Since(a.start).Truncate(time.Second).String(),
+		Resources: a.monitor.Sample(),
+		Buffers:   poolUsage(),
+	}
+	for _, x := range a.relays {
+		if x.name == "" {
+			c.tenantStats = x.stats()
+		} else {
+			c.Tenants = append(c.Tenants, x.stats())
+		}
+	}
+	writeJSON(w, c)
+}
+
+func (r *relay) stats() tenantStats {
+	s := tenantStats{
+		Name:      r.name,
+		Received:  r.in.load(),
+		Rejected:  r.rejected.load(),
+		Discarded: r.discarded.load(),
+		Corrupted: r.corrupted.load(),
+		Routes:    make([]routeStats, len(r.routes)),
+	}
+	if r.seq != nil {
+		s.Sequence = r.seq.Stats()
+	}
+	if r.reseq != nil {
+		s.Reorder = r.reseq.stats()
+	}
+	if r.fec != nil {
+		s.FEC = r.fec.load()
+	}
+	if r.arbiter != nil {
+		s.Sources = r.arbiter.stats()
+	}
+	if r.archive != nil {
+		s.Archive = r.archive.stats()
+	}
+	if r.probes != nil {
+		s.Latency = r.probes.stats()
+	}
+	for i, rt := range r.routes {
+		s.Routes[i] = routeStats{
+			Name:     rt.Name,
+			Sent:     rt.sent.load(),
+			Dropped:  rt.dropped.load(),
+			Overflow: rt.overflow.load(),
+			Queue:    rt.queue(),
+			Filtered: rt.filtered.load(),
+			Errors:   atomic.LoadUint64(&rt.errors),
+			Paused:   rt.Paused(),
+		}
+		if rt.latency != nil {
+			sum := rt.latency.Summary()
+			s.Routes[i].Latency = &sum
+		}
+		if rt.beat != nil {
+			s.Routes[i].Beats = rt.beat.sent()
+		}
+		if rt.timing != nil {
+			s.Routes[i].Probes = rt.timing.sent()
+		}
+		if rt.probe != nil {
+			s.Routes[i].Remote = rt.probe.stats()
+		}
+		switch c := rt.conn.(type) {
+		case *fanout:
+			s.Routes[i].Clients = c.stats()
+		case *group:
+			s.Routes[i].Members = c.stats()
+		}
+	}
+	return s
+}
+
+func (a *admin) drainInput(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	for _, x := range a.relays {
+		x.Stop()
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *admin) pauseRoute(w http.ResponseWriter, r *http.Request) {
+	var (
+		name = strings.TrimPrefix(r.URL.Path, "/pause/")
+		rt   *route
+	)
+	for _, x := range a.relays {
+		if rt = x.Lookup(name); rt != nil {
+			break
+		}
+	}
+	if rt == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		rt.Pause()
+	case http.MethodDelete:
+		rt.Resume()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,125 @@
+package duplicate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+const DefaultArbitrationWindow = 4096
+
+// Arbitration selects, among redundant listeners, the one whose packets are
+// forwarded (see the arbitration table).
+type Arbitration struct {
+	Mode   string
+	Window int
+}
+
+type sourceStats struct {
+	Remote     string  `json:"remote"`
+	Received   counter `json:"received"`
+	Forwarded  counter `json:"forwarded"`
+	Duplicates counter `json:"duplicates"`
+}
+
+type arbiter struct {
+	key     func([]byte) (uint64, bool)
+	sources []*sourceStats
+
+	mu   sync.Mutex
+	seen map[uint64]struct{}
+	keys []uint64
+	pos  int
+}
+
+func newArbiter(a Arbitration, ls []Listener) (*arbiter, error) {
+	var key func([]byte) (uint64, bool)
+	switch a.Mode {
+	case "":
+		return nil, nil
+	case "hash":
+		key = keyHash
+	case "ccsds":
+		key = keyCCSDS
+	default:
+		return nil, fmt.Errorf("%s: unsupported arbitration mode (available: hash, ccsds)", a.Mode)
+	}
+	if len(ls) < 2 {
+		return nil, fmt.Errorf("arbitration: at least two listeners expected")
+	}
+	if a.Window < 0 {
+		return nil, fmt.Errorf("arbitration: invalid window (%d)", a.Window)
+	}
+	if a.Window == 0 {
+		a.Window = DefaultArbitrationWindow
+	}
+	x := arbiter{
+		key:  key,
+		seen: make(map[uint64]struct{}, a.Window),
+		keys: make([]uint64, 0, a.Window),
+	}
+	for _, l := range ls {
+		x.sources = append(x.sources, &sourceStats{Remote: l.Remote})
+	}
+	return &x, nil
+}
+
+func (a *arbiter) accept(i int, xs []byte) bool {
+	src := a.sources[i]
+	src.Received.count(len(xs))
+	k, ok := a.key(xs)
+	if ok && !a.first(k) {
+		src.Duplicates.count(len(xs))
+		return false
+	}
+	src.Forwarded.count(len(xs))
+	return true
+}
+
+func (a *arbiter) first(k uint64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.seen[k]; ok {
+		return false
+	}
+	if len(a.keys) < cap(a.keys) {
+		a.keys = append(a.keys, k)
+	} else {
+		delete(a.seen, a.keys[a.pos])
+		a.keys[a.pos] = k
+		a.pos = (a.pos + 1) % len(a.keys)
+	}
+	a.seen[k] = struct{}{}
+	return true
+}
+
+func (a *arbiter) stats() []sourceStats {
+	ss := make([]sourceStats, len(a.sources))
+	for i, s := range a.sources {
+		ss[i] = sourceStats{
+			Remote:     s.Remote,
+			Received:   s.Received.load(),
+			Forwarded:  s.Forwarded.load(),
+			Duplicates: s.Duplicates.load(),
+		}
+	}
+	return ss
+}
+
+func keyHash(xs []byte) (uint64, bool) {
+	h := fnv.New64a()
+	h.Write(xs)
+	return h.Sum64(), true
+}
+
+func keyCCSDS(xs []byte) (uint64, bool) {
+	if len(xs) < ccsdsHeaderLen {
+		return 0, false
+	}
+	var (
+		apid = binary.BigEndian.Uint16(xs) & 0x07ff
+		seq  = binary.BigEndian.Uint16(xs[2:]) & 0x3fff
+	)
+	return uint64(apid)<<14 | uint64(seq), true
+}
@@ -0,0 +1,139 @@
+package duplicate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultArchivePrefix   = "duplicate"
+	DefaultArchiveInterval = time.Hour
+)
+
+// Archive configures the files where the incoming packets of a tenant are
+// archived.
+type Archive struct {
+	Directory string
+	Prefix    string
+	Interval  Duration
+	Size      Size
+}
+
+func (a Archive) isSet() bool {
+	return a.Directory != ""
+}
+
+type archiveStats struct {
+	File    string  `json:"file,omitempty"`
+	Written counter `json:"written"`
+	Errors  uint64  `json:"errors"`
+}
+
+type archiver struct {
+	dir      string
+	prefix   string
+	interval time.Duration
+	size     int
+
+	logger  *log.Logger
+	clock   clock
+	file    *os.File
+	name    atomic.Value
+	opened  time.Time
+	current int
+	seq     int
+
+	written counter
+	errors  uint64
+}
+
+func newArchiver(a Archive, clk clock, logger *log.Logger) (*archiver, error) {
+	if !a.isSet() {
+		return nil, nil
+	}
+	if err := os.MkdirAll(a.Directory, 0755); err != nil {
+		return nil, err
+	}
+	w := archiver{
+		dir:      a.Directory,
+		prefix:   a.Prefix,
+		interval: DefaultArchiveInterval,
+		size:     int(a.Size.In(1 << 20)),
+		logger:   logger,
+		clock:    clk,
+	}
+	if w.prefix == "" {
+		w.prefix = DefaultArchivePrefix
+	}
+	if a.Interval.isSet() {
+		w.interval = a.Interval.In(time.Second)
+	}
+	w.name.Store("")
+	return &w, nil
+}
+
+func (a *archiver) Write(xs []byte) (int, error) {
+	if a.file == nil || a.expired(len(xs)) {
+		if err := a.roll(); err != nil {
+			atomic.AddUint64(&a.errors, 1)
+			return len(xs), nil
+		}
+	}
+	buf := getPacket(4 + len(xs))
+	defer putPacket(buf)
+	binary.BigEndian.PutUint32(*buf, uint32(len(xs)))
+	copy((*buf)[4:], xs)
+	if _, err := a.file.Write(*buf); err != nil {
+		atomic.AddUint64(&a.errors, 1)
+		return len(xs), nil
+	}
+	a.current += len(*buf)
+	a.written.count(len(xs))
+	return len(xs), nil
+}
+
+func (a *archiver) expired(n int) bool {
+	if a.clock.Since(a.opened) >= a.interval {
+		return true
+	}
+	return a.size > 0 && a.current > 0 && a.current+4+n > a.size
+}
+
+func (a *archiver) roll() error {
+	if err := a.Close(); err != nil {
+		a.logger.Printf("archive: %s", err)
+	}
+	now := a.clock.Now().UTC()
+	a.seq++
+	file := fmt.Sprintf("%s_%s_%04d.dat", a.prefix, now.Format("20060102_150405"), a.seq)
+	f, err := os.OpenFile(filepath.Join(a.dir, file), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		a.logger.Printf("archive: %s", err)
+		return err
+	}
+	a.file, a.opened, a.current = f, now, 0
+	a.name.Store(f.Name())
+	return nil
+}
+
+func (a *archiver) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+func (a *archiver) stats() *archiveStats {
+	return &archiveStats{
+		File:    a.name.Load().(string),
+		Written: a.written.load(),
+		Errors:  atomic.LoadUint64(&a.errors),
+	}
+}
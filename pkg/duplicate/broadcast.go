@@ -0,0 +1,225 @@
+package duplicate
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const broadcastPacketSize = 128
+
+type slot struct {
+	seq  uint64
+	pos  uint64
+	size int
+	when time.Time
+}
+
+// broadcast is a ring written by the relay and read by several delayed routes,
+// each through its own cursor, so that a packet is copied once in memory for
+// all of them. The writer never waits: when the buffer is full, the oldest
+// packets are overwritten and the cursors that did not read them yet count
+// them as dropped.
+type broadcast struct {
+	buffer []byte
+	slots  []slot
+	recv   *meta
+	clock  clock
+
+	mu     sync.RWMutex
+	head   uint64
+	total  uint64
+	notify chan struct{}
+	closed bool
+}
+
+func newBroadcast(size int, clk clock) *broadcast {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	n := size / broadcastPacketSize
+	if n < DefaultBacklog {
+		n = DefaultBacklog
+	}
+	return &broadcast{
+		buffer: make([]byte, size),
+		slots:  make([]slot, n),
+		notify: make(chan struct{}),
+		clock:  clk,
+	}
+}
+
+func (b *broadcast) Write(xs []byte) (int, error) {
+	size := len(xs)
+	if size == 0 || size > len(b.buffer) {
+		return size, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.EOF
+	}
+	offset := int(b.total % uint64(len(b.buffer)))
+	if n := copy(b.buffer[offset:], xs); n < size {
+		copy(b.buffer, xs[n:])
+	}
+	when := b.clock.Now()
+	if b.recv != nil {
+		when = b.recv.when
+	}
+	b.slots[b.head%uint64(len(b.slots))] = slot{
+		seq:  b.head,
+		pos:  b.total,
+		size: size,
+		when: when,
+	}
+	b.head++
+	b.total += uint64(size)
+	b.wake()
+	return size, nil
+}
+
+func (b *broadcast) wake() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+func (b *broadcast) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	b.closed = true
+	b.wake()
+	return nil
+}
+
+func (b *broadcast) intact(s slot, seq uint64) bool {
+	if s.seq != seq {
+		return false
+	}
+	return b.total <= uint64(len(b.buffer)) || s.pos >= b.total-uint64(len(b.buffer))
+}
+
+type cursor struct {
+	*broadcast
+	next    uint64
+	wait    time.Duration
+	latency *histogram
+	dropped *counter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (b *broadcast) Cursor(ctx context.Context, wait time.Duration, latency *histogram, dropped *counter) *cursor {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	c := cursor{
+		broadcast: b,
+		next:      b.head,
+		wait:      wait,
+		latency:   latency,
+		dropped:   dropped,
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	return &c
+}
+
+func (c *cursor) Read(xs []byte) (int, error) {
+	for {
+		s, notify, err := c.peek()
+		if err != nil {
+			return 0, err
+		}
+		if notify != nil {
+			select {
+			case <-notify:
+				continue
+			case <-c.ctx.Done():
+				return 0, io.EOF
+			}
+		}
+		if wait := c.clock.Until(s.when.Add(c.wait)); wait > 0 {
+			select {
+			case <-c.clock.After(wait):
+			case <-c.ctx.Done():
+				return 0, io.EOF
+			}
+		}
+		if n, ok := c.copy(xs, s); ok {
+			if c.latency != nil {
+				c.latency.Observe(c.clock.Since(s.when))
+			}
+			return n, nil
+		}
+	}
+}
+
+func (c *cursor) peek() (slot, chan struct{}, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for {
+		if c.next == c.head {
+			if c.closed {
+				return slot{}, nil, io.EOF
+			}
+			return slot{}, c.notify, nil
+		}
+		s := c.slots[c.next%uint64(len(c.slots))]
+		if !c.intact(s, c.next) {
+			c.dropped.count(s.size)
+			atomic.AddUint64(&c.next, 1)
+			continue
+		}
+		return s, nil, nil
+	}
+}
+
+func (c *cursor) copy(xs []byte, s slot) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	atomic.AddUint64(&c.next, 1)
+	if !c.intact(c.slots[s.seq%uint64(len(c.slots))], s.seq) {
+		c.dropped.count(s.size)
+		return 0, false
+	}
+	if len(xs) < s.size {
+		c.dropped.count(s.size)
+		return 0, false
+	}
+	offset := int(s.pos % uint64(len(c.buffer)))
+	n := copy(xs[:s.size], c.buffer[offset:])
+	if n < s.size {
+		copy(xs[n:s.size], c.buffer)
+	}
+	return s.size, true
+}
+
+func (c *cursor) depth() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return int(c.head - atomic.LoadUint64(&c.next))
+}
+
+func (c *cursor) Close() error {
+	c.cancel()
+	return nil
+}
+
+func (c *cursor) Abort() {
+	c.cancel()
+}
+
+func (r Route) shareable() bool {
+	if !r.Delay.isSet() || r.Jitter.isSet() || r.Interval.isSet() || r.Annotate || r.BufferFile != "" || r.StrictFile != "" {
+		return false
+	}
+	if r.Envelope != "" || r.Strip > 0 || r.Prepend != "" || len(r.Apids) > 0 {
+		return false
+	}
+	return r.MinSize <= 0 && r.MaxSize <= 0 && !r.Shift.isSet()
+}
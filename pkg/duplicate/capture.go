@@ -0,0 +1,230 @@
+package duplicate
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	DefaultCaptureBefore = 100
+	DefaultCaptureAfter  = 100
+)
+
+const (
+	pcapMagic   = 0xa1b2c3d4
+	pcapSnaplen = 1 << 16
+	pcapRawIP   = 101
+)
+
+// Capture configures the packets recorded around an anomaly of the incoming
+// stream.
+type Capture struct {
+	Directory string
+	Prefix    string
+	Before    int
+	After     int
+}
+
+func (c Capture) isSet() bool {
+	return c.Directory != ""
+}
+
+type captured struct {
+	meta
+	body []byte
+}
+
+type capturer struct {
+	dir    string
+	prefix string
+	after  int
+	logger *log.Logger
+	clock  clock
+
+	mu      sync.Mutex
+	history []captured
+	head    int
+	file    *os.File
+	writer  *bufio.Writer
+	remain  int
+}
+
+func newCapturer(c Capture, clk clock, logger *log.Logger) (*capturer, error) {
+	if !c.isSet() {
+		return nil, nil
+	}
+	if err := os.MkdirAll(c.Directory, 0755); err != nil {
+		return nil, err
+	}
+	x := capturer{
+		dir:    c.Directory,
+		prefix: c.Prefix,
+		after:  c.After,
+		logger: logger,
+		clock:  clk,
+	}
+	if x.prefix == "" {
+		x.prefix = DefaultArchivePrefix
+	}
+	if x.after <= 0 {
+		x.after = DefaultCaptureAfter
+	}
+	before := c.Before
+	if before <= 0 {
+		before = DefaultCaptureBefore
+	}
+	x.history = make([]captured, 0, before)
+	return &x, nil
+}
+
+func (c *capturer) Record(xs []byte, m meta) {
+	r := captured{
+		meta: m,
+		body: append([]byte(nil), xs...),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.history) < cap(c.history) {
+		c.history = append(c.history, r)
+	} else {
+		c.history[c.head] = r
+		c.head = (c.head + 1) % len(c.history)
+	}
+	if c.file == nil {
+		return
+	}
+	c.write(r)
+	if c.remain--; c.remain <= 0 {
+		c.finish()
+	}
+}
+
+func (c *capturer) Trigger(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file != nil {
+		c.remain = c.after
+		return
+	}
+	now := c.clock.Now().UTC()
+	file := fmt.Sprintf("%s_%s_%s.pcap", c.prefix, now.Format("20060102_150405.000"), reason)
+	f, err := os.Create(filepath.Join(c.dir, file))
+	if err != nil {
+		c.logger.Printf("capture: %s", err)
+		return
+	}
+	c.file, c.writer, c.remain = f, bufio.NewWriter(f), c.after
+
+	pcapHeader(c.writer)
+	for i := range c.history {
+		c.write(c.history[(c.head+i)%len(c.history)])
+	}
+	c.logger.Printf("capture: %s: writing packets around %s anomaly", f.Name(), reason)
+}
+
+func (c *capturer) write(r captured) {
+	pcapPacket(c.writer, r.meta, r.body)
+}
+
+func (c *capturer) finish() {
+	err := c.writer.Flush()
+	if e := c.file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		c.logger.Printf("capture: %s", err)
+	}
+	c.file, c.writer = nil, nil
+}
+
+func (c *capturer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file != nil {
+		c.finish()
+	}
+	return nil
+}
+
+func pcapHeader(w io.Writer) {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnaplen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapRawIP)
+	w.Write(hdr[:])
+}
+
+func pcapPacket(w io.Writer, m meta, body []byte) {
+	xs := datagram(m.addr, body)
+	if len(xs) > pcapSnaplen {
+		xs = xs[:pcapSnaplen]
+	}
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(m.when.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(m.when.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(xs)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(xs)))
+	w.Write(hdr[:])
+	w.Write(xs)
+}
+
+func datagram(addr net.Addr, body []byte) []byte {
+	var (
+		ip   net.IP
+		port int
+	)
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	}
+	if len(body) > 0xFFFF-48 {
+		body = body[:0xFFFF-48]
+	}
+	udp := make([]byte, 8+len(body))
+	binary.BigEndian.PutUint16(udp[0:], uint16(port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[8:], body)
+
+	if ip4 := ip.To4(); ip4 != nil || ip == nil {
+		hdr := make([]byte, 20)
+		hdr[0] = 0x45
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(hdr)+len(udp)))
+		hdr[8], hdr[9] = 64, 17
+		if ip4 != nil {
+			copy(hdr[12:], ip4)
+		}
+		binary.BigEndian.PutUint16(hdr[10:], checksum(hdr))
+		return append(hdr, udp...)
+	}
+	hdr := make([]byte, 40)
+	hdr[0] = 0x60
+	binary.BigEndian.PutUint16(hdr[4:], uint16(len(udp)))
+	hdr[6], hdr[7] = 17, 64
+	copy(hdr[8:], ip.To16())
+	return append(hdr, udp...)
+}
+
+func checksum(xs []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(xs); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(xs[i:]))
+	}
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
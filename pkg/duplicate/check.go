@@ -0,0 +1,276 @@
+package duplicate
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+type checker struct {
+	problems []error
+}
+
+func (x *checker) report(where string, err error) {
+	if err != nil {
+		x.problems = append(x.problems, fmt.Errorf("%s: %w", where, err))
+	}
+}
+
+// Check validates the configuration without opening any socket: the addresses
+// are resolved, the interfaces looked up, the certificates loaded and the other
+// options checked. It gives all the problems found.
+func (c Config) Check() []error {
+	var x checker
+	x.check(c)
+	return x.problems
+}
+
+func (x *checker) check(c Config) {
+	ts, err := c.tenants()
+	x.report("config", err)
+	x.report("storage", c.Storage.check())
+	_, err = c.Clock.clock()
+	x.report("clock", err)
+	for _, t := range ts {
+		t, err := c.Storage.tenant(t)
+		if err != nil {
+			x.report(t.String(), err)
+			continue
+		}
+		x.checkTenant(t)
+	}
+}
+
+func (x *checker) checkTenant(t Tenant) {
+	where := t.String()
+	x.report(where, t.checkBudget())
+	if len(t.listeners()) == 0 {
+		x.report(where, fmt.Errorf("no listener configured"))
+	}
+	for _, l := range t.listeners() {
+		x.report(where+": "+l.Remote, checkListener(l, t.Certificate))
+	}
+	_, err := unwrapReader(nil, t.Envelope, t.Window, t.Hold.In(time.Millisecond), wallClock{})
+	x.report(where, err)
+	_, err = newArbiter(t.Arbitration, t.listeners())
+	x.report(where+": arbitration", err)
+	_, err = t.Decryption.aead()
+	x.report(where+": decryption", err)
+	_, err = trailerHash(t.Trailer, t.TrailerKey)
+	x.report(where, err)
+	_, err = parseNetworks(t.Allow)
+	x.report(where+": allow", err)
+	_, err = parseNetworks(t.Deny)
+	x.report(where+": deny", err)
+	_, err = newTracker(t.Sequence, nil)
+	x.report(where+": sequence", err)
+	for _, r := range t.routes() {
+		x.checkRoute(where, r)
+	}
+}
+
+func (x *checker) checkRoute(where string, r Route) {
+	name := r.Name
+	if name == "" {
+		name = r.Addr
+	}
+	where += ": " + name
+	if len(r.Members) > 0 {
+		switch r.Policy {
+		case "", "all", "failover", "round-robin":
+		default:
+			x.report(where, fmt.Errorf("%s: unsupported group policy", r.Policy))
+		}
+		for _, m := range r.Members {
+			x.checkRoute(where, m)
+		}
+	} else {
+		x.report(where, checkRemote(r))
+		switch r.Framing {
+		case "", "length":
+		default:
+			x.report(where, fmt.Errorf("%s: unsupported framing", r.Framing))
+		}
+		x.report(where, checkTransform(r.Transform))
+		_, err := r.Encryption.aead()
+		x.report(where, err)
+		_, err = trailerHash(r.Trailer, r.TrailerKey)
+		x.report(where, err)
+		x.report(where, checkFEC(r.FEC))
+	}
+	for _, fn := range routeChecks {
+		x.report(where, fn(r))
+	}
+}
+
+// routeChecks validates the options of a route that do not depend on its
+// remote. Setup stops at the first failing check, check reports all of them.
+var routeChecks = []func(Route) error{
+	func(r Route) error {
+		_, err := envelopeWriter(nil, r.Envelope, nil)
+		return err
+	},
+	func(r Route) error {
+		if r.Annotate && r.Envelope == "" {
+			return fmt.Errorf("annotate requires an envelope")
+		}
+		return nil
+	},
+	func(r Route) error {
+		_, err := parseApids(r.Apids)
+		return err
+	},
+	func(r Route) error {
+		_, err := rewriteWriter(nil, r.Strip, r.Prepend, nil)
+		return err
+	},
+	func(r Route) error {
+		return checkOverflow(r.Overflow)
+	},
+	Route.checkShare,
+	Route.checkCompress,
+	Route.checkShift,
+	Route.checkBufferFile,
+	Route.checkStrict,
+	Route.checkGSO,
+	Route.checkWorkers,
+	Route.checkLatencyProbe,
+	Route.checkMTU,
+	func(r Route) error {
+		_, err := checkPause(r.OnPause)
+		return err
+	},
+	Route.checkOutside,
+	func(r Route) error {
+		_, err := r.Heartbeat.payload()
+		return err
+	},
+	func(r Route) error {
+		if !r.Probe.isSet() {
+			return nil
+		}
+		switch r.Proto {
+		case "", DefaultProtocol, "tcp":
+			return nil
+		default:
+			return fmt.Errorf("%s: probe not supported", r.Proto)
+		}
+	},
+}
+
+func (r Route) check() error {
+	for _, fn := range routeChecks {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkListener(l Listener, cert Certificate) error {
+	if l.GRO && l.Proto != "" && l.Proto != DefaultProtocol {
+		return fmt.Errorf("gro requires an udp listener")
+	}
+	if err := l.checkTimestamp(); err != nil {
+		return err
+	}
+	switch l.Proto {
+	case "", DefaultProtocol:
+		n, err := network(DefaultProtocol, l.Network)
+		if err != nil {
+			return err
+		}
+		addr, err := net.ResolveUDPAddr(n, l.Remote)
+		if err != nil {
+			return err
+		}
+		if len(l.Sources) > 0 {
+			if !addr.IP.IsMulticast() {
+				return fmt.Errorf("ssm-sources requires a multicast group")
+			}
+			if _, err := parseSources(l.Sources); err != nil {
+				return err
+			}
+		}
+	case "tcp":
+		n, err := network(l.Proto, l.Network)
+		if err != nil {
+			return err
+		}
+		if _, err := net.ResolveTCPAddr(n, l.Remote); err != nil {
+			return err
+		}
+		if _, err := framer(l.Framing); err != nil {
+			return err
+		}
+		if err := checkCompress(l.Decompress); err != nil {
+			return err
+		}
+		if _, err := cert.Server(); err != nil {
+			return err
+		}
+	case "raw":
+		if err := l.checkRaw(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%s: unsupported protocol", l.Proto)
+	}
+	return checkInterface(l.Ifi)
+}
+
+func checkRemote(r Route) error {
+	if r.Listen {
+		if r.Proto != "" && r.Proto != "tcp" {
+			return fmt.Errorf("only tcp routes can listen for clients")
+		}
+		if _, err := net.ResolveTCPAddr("tcp", r.Addr); err != nil {
+			return err
+		}
+		_, err := r.Certificate.Server()
+		return err
+	}
+	switch proto := r.Proto; proto {
+	case "", DefaultProtocol:
+		n, err := network(DefaultProtocol, r.Network)
+		if err != nil {
+			return err
+		}
+		if _, err := net.ResolveUDPAddr(n, r.Addr); err != nil {
+			return err
+		}
+		if _, err := localAddr(n, r); err != nil {
+			return err
+		}
+	case "tcp":
+		n, err := network(proto, r.Network)
+		if err != nil {
+			return err
+		}
+		if _, err := net.ResolveTCPAddr(n, r.Addr); err != nil {
+			return err
+		}
+		if _, err := localAddr(n, r); err != nil {
+			return err
+		}
+	default:
+		if _, ok := sinks[proto]; !ok {
+			return fmt.Errorf("%s: unsupported protocol (available: udp, tcp, %s)", proto, strings.Join(Sinks(), ", "))
+		}
+		if proto == "wss" {
+			if _, err := r.Certificate.Client(""); err != nil {
+				return err
+			}
+		}
+	}
+	return checkInterface(r.Ifi)
+}
+
+func checkInterface(ifi string) error {
+	if ifi == "" {
+		return nil
+	}
+	_, err := net.InterfaceByName(ifi)
+	return err
+}
@@ -0,0 +1,100 @@
+package duplicate
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net"
+)
+
+// ErrCorrupted is returned for the packets whose checksum or framing is
+// invalid.
+var ErrCorrupted = errors.New("corrupted packet")
+
+func trailerHash(kind, key string) (hash.Hash, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case "crc32":
+		return crc32.NewIEEE(), nil
+	case "hmac-sha256":
+		if key == "" {
+			return nil, fmt.Errorf("%s: trailer-key expected", kind)
+		}
+		k, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("trailer-key: invalid hex key: %w", err)
+		}
+		return hmac.New(sha256.New, k), nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported trailer (available: crc32, hmac-sha256)", kind)
+	}
+}
+
+type signer struct {
+	io.WriteCloser
+	hash hash.Hash
+	buf  []byte
+}
+
+func trailerWriter(w io.WriteCloser, kind, key string) (io.WriteCloser, error) {
+	h, err := trailerHash(kind, key)
+	if err != nil || h == nil {
+		return w, err
+	}
+	return &signer{WriteCloser: w, hash: h}, nil
+}
+
+func (s *signer) Write(xs []byte) (int, error) {
+	s.hash.Reset()
+	s.hash.Write(xs)
+	s.buf = s.hash.Sum(append(s.buf[:0], xs...))
+	if _, err := s.WriteCloser.Write(s.buf); err != nil {
+		return 0, err
+	}
+	return len(xs), nil
+}
+
+type verifier struct {
+	PacketReader
+	hash hash.Hash
+	buf  []byte
+}
+
+func trailerReader(r PacketReader, kind, key string) (PacketReader, error) {
+	h, err := trailerHash(kind, key)
+	if err != nil || h == nil {
+		return r, err
+	}
+	v := verifier{
+		PacketReader: r,
+		hash:         h,
+		buf:          make([]byte, MaxPacketSize),
+	}
+	return &v, nil
+}
+
+func (v *verifier) ReadFrom(xs []byte) (int, net.Addr, error) {
+	n, addr, err := v.PacketReader.ReadFrom(v.buf)
+	if err != nil {
+		return n, addr, err
+	}
+	size := n - v.hash.Size()
+	if size < 0 {
+		return n, addr, ErrCorrupted
+	}
+	v.hash.Reset()
+	v.hash.Write(v.buf[:size])
+	if !hmac.Equal(v.hash.Sum(nil), v.buf[size:n]) {
+		return n, addr, ErrCorrupted
+	}
+	if size > len(xs) {
+		return 0, addr, io.ErrShortBuffer
+	}
+	return copy(xs, v.buf[:size]), addr, nil
+}
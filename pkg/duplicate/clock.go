@@ -0,0 +1,156 @@
+package duplicate
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock configures a simulated clock driving the delays of the routes.
+type Clock struct {
+	Speed float64
+	Start string
+}
+
+type clock interface {
+	Now() time.Time
+	Since(time.Time) time.Duration
+	Until(time.Time) time.Duration
+	After(time.Duration) <-chan time.Time
+	NewTimer(time.Duration) *timer
+	AfterFunc(time.Duration, func()) *timer
+	NewTicker(time.Duration) *ticker
+}
+
+// timer is a timer created by a clock. C is nil when the timer was created by
+// AfterFunc.
+type timer struct {
+	C     <-chan time.Time
+	stop  func() bool
+	reset func(time.Duration) bool
+}
+
+func (t *timer) Stop() bool {
+	return t.stop()
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	return t.reset(d)
+}
+
+type ticker struct {
+	C    <-chan time.Time
+	stop func()
+}
+
+func (t *ticker) Stop() {
+	t.stop()
+}
+
+func (c Clock) isSet() bool {
+	return c.Speed != 0 || c.Start != ""
+}
+
+func (c Clock) clock() (clock, error) {
+	if !c.isSet() {
+		return wallClock{}, nil
+	}
+	if c.Speed < 0 {
+		return nil, fmt.Errorf("%f: invalid clock speed", c.Speed)
+	}
+	s := scaledClock{
+		speed:  c.Speed,
+		origin: time.Now(),
+	}
+	if s.speed == 0 {
+		s.speed = 1
+	}
+	s.start = s.origin
+	if c.Start != "" {
+		w, err := time.Parse(time.RFC3339, c.Start)
+		if err != nil {
+			return nil, err
+		}
+		s.start = w
+	}
+	return s, nil
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (wallClock) Until(t time.Time) time.Duration {
+	return time.Until(t)
+}
+
+func (wallClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (wallClock) NewTimer(d time.Duration) *timer {
+	t := time.NewTimer(d)
+	return &timer{C: t.C, stop: t.Stop, reset: t.Reset}
+}
+
+func (wallClock) AfterFunc(d time.Duration, fn func()) *timer {
+	t := time.AfterFunc(d, fn)
+	return &timer{stop: t.Stop, reset: t.Reset}
+}
+
+func (wallClock) NewTicker(d time.Duration) *ticker {
+	t := time.NewTicker(d)
+	return &ticker{C: t.C, stop: t.Stop}
+}
+
+type scaledClock struct {
+	speed  float64
+	origin time.Time
+	start  time.Time
+}
+
+func (c scaledClock) Now() time.Time {
+	return c.start.Add(time.Duration(float64(time.Since(c.origin)) * c.speed))
+}
+
+func (c scaledClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c scaledClock) Until(t time.Time) time.Duration {
+	return t.Sub(c.Now())
+}
+
+func (c scaledClock) After(d time.Duration) <-chan time.Time {
+	return time.After(c.real(d))
+}
+
+func (c scaledClock) NewTimer(d time.Duration) *timer {
+	t := time.NewTimer(c.real(d))
+	return &timer{C: t.C, stop: t.Stop, reset: c.reset(t)}
+}
+
+func (c scaledClock) AfterFunc(d time.Duration, fn func()) *timer {
+	t := time.AfterFunc(c.real(d), fn)
+	return &timer{stop: t.Stop, reset: c.reset(t)}
+}
+
+func (c scaledClock) NewTicker(d time.Duration) *ticker {
+	t := time.NewTicker(c.real(d))
+	return &ticker{C: t.C, stop: t.Stop}
+}
+
+func (c scaledClock) reset(t *time.Timer) func(time.Duration) bool {
+	return func(d time.Duration) bool {
+		return t.Reset(c.real(d))
+	}
+}
+
+func (c scaledClock) real(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / c.speed)
+}
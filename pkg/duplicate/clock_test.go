@@ -0,0 +1,365 @@
+package duplicate
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock whose time only moves when Advance is called. The
+// functions given to AfterFunc are called by Advance.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+	fn     func()
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *fakeClock) Until(t time.Time) time.Duration {
+	return t.Sub(c.Now())
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) *timer {
+	w := fakeWaiter{c: make(chan time.Time, 1)}
+	c.add(&w, d)
+	return &timer{C: w.c, stop: c.stop(&w), reset: c.reset(&w)}
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, fn func()) *timer {
+	w := fakeWaiter{fn: fn}
+	c.add(&w, d)
+	return &timer{stop: c.stop(&w), reset: c.reset(&w)}
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) *ticker {
+	w := fakeWaiter{c: make(chan time.Time, 1), period: d}
+	c.add(&w, d)
+	stop := c.stop(&w)
+	return &ticker{C: w.c, stop: func() { stop() }}
+}
+
+// Advance moves the time of the clock by d and fires, in order, the timers
+// and tickers expiring meanwhile.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for len(c.waiters) > 0 && !c.waiters[0].when.After(end) {
+		w := c.waiters[0]
+		c.now = w.when
+		c.waiters = c.waiters[1:]
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+			c.insert(w)
+		}
+		now := c.now
+		c.mu.Unlock()
+		if w.fn != nil {
+			w.fn()
+		} else {
+			select {
+			case w.c <- now:
+			default:
+			}
+		}
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// pending gives the number of timers and tickers not yet expired.
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+func (c *fakeClock) add(w *fakeWaiter, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.when = c.now.Add(d)
+	c.insert(w)
+}
+
+func (c *fakeClock) insert(w *fakeWaiter) {
+	i := sort.Search(len(c.waiters), func(i int) bool {
+		return c.waiters[i].when.After(w.when)
+	})
+	c.waiters = append(c.waiters, nil)
+	copy(c.waiters[i+1:], c.waiters[i:])
+	c.waiters[i] = w
+}
+
+func (c *fakeClock) remove(w *fakeWaiter) bool {
+	for i := range c.waiters {
+		if c.waiters[i] == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (c *fakeClock) stop(w *fakeWaiter) func() bool {
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.remove(w)
+	}
+}
+
+func (c *fakeClock) reset(w *fakeWaiter) func(time.Duration) bool {
+	return func(d time.Duration) bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		active := c.remove(w)
+		w.when = c.now.Add(d)
+		c.insert(w)
+		return active
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	c := newFakeClock()
+	var (
+		start = c.Now()
+		fired []string
+	)
+	short := c.NewTimer(time.Second)
+	c.AfterFunc(2*time.Second, func() {
+		fired = append(fired, "func")
+	})
+	stopped := c.NewTimer(time.Second)
+	stopped.Stop()
+	tick := c.NewTicker(time.Second)
+	defer tick.Stop()
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-short.C:
+		t.Fatalf("timer fired before its time")
+	default:
+	}
+	c.Advance(time.Second)
+	if got := <-short.C; !got.Equal(start.Add(time.Second)) {
+		t.Fatalf("timer fired at %s", got)
+	}
+	if len(fired) != 0 {
+		t.Fatalf("function called before its time")
+	}
+	c.Advance(time.Second)
+	if len(fired) != 1 {
+		t.Fatalf("function not called")
+	}
+	select {
+	case <-stopped.C:
+		t.Fatalf("stopped timer fired")
+	default:
+	}
+	if got := <-tick.C; !got.Equal(start.Add(time.Second)) {
+		t.Fatalf("ticker fired at %s", got)
+	}
+	c.Advance(time.Second)
+	if got := <-tick.C; !got.Equal(start.Add(3 * time.Second)) {
+		t.Fatalf("ticker fired at %s", got)
+	}
+	if got := c.Since(start); got != 3500*time.Millisecond {
+		t.Fatalf("unexpected elapsed time: %s", got)
+	}
+}
+
+func TestScaledClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c, err := Clock{Speed: 4, Start: start.Format(time.RFC3339)}.clock()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s := c.(scaledClock)
+	if got := s.real(4 * time.Second); got != time.Second {
+		t.Fatalf("scaled duration: want 1s, got %s", got)
+	}
+	if now := s.Now(); now.Before(start) || now.Sub(start) > time.Minute {
+		t.Fatalf("unexpected time: %s", now)
+	}
+	if _, err := (Clock{Speed: -1}).clock(); err == nil {
+		t.Fatalf("negative speed accepted")
+	}
+}
+
+func TestHeartbeatIdle(t *testing.T) {
+	c := newFakeClock()
+	var w syncBuffer
+	h, err := heartbeatWriter(&w, Heartbeat{Interval: Duration{value: 4 * time.Second}, Payload: "beat"}, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer h.Close()
+	waitFor(t, func() bool { return c.pending() == 1 })
+
+	h.Write([]byte("data"))
+	c.Advance(3 * time.Second)
+	h.Write([]byte("data"))
+	c.Advance(3 * time.Second)
+	if h.sent() != 0 {
+		t.Fatalf("heartbeat sent while the route is active")
+	}
+	c.Advance(2 * time.Second)
+	waitFor(t, func() bool { return h.sent() == 1 })
+	if got := w.String(); got != "datadatabeat" {
+		t.Fatalf("unexpected stream: %q", got)
+	}
+}
+
+func TestSegmenterFlush(t *testing.T) {
+	c := newFakeClock()
+	var w syncBuffer
+	s := segmentWriter(&w, 8, false, c)
+	defer s.Close()
+
+	s.Write([]byte("abc"))
+	s.Write([]byte("def"))
+	c.Advance(mtuFlush / 2)
+	if w.Len() != 0 {
+		t.Fatalf("datagram sent before the flush delay")
+	}
+	s.Write([]byte("gh"))
+	if w.Len() != 0 {
+		t.Fatalf("datagram sent before it overflows")
+	}
+	s.Write([]byte("ij"))
+	if got := w.String(); got != "abcdefgh" {
+		t.Fatalf("full datagram not sent: %q", got)
+	}
+	c.Advance(mtuFlush)
+	if got := w.String(); got != "abcdefghij" {
+		t.Fatalf("datagram not sent after the flush delay: %q", got)
+	}
+}
+
+func TestFECPartialBlock(t *testing.T) {
+	c := newFakeClock()
+	var w syncBuffer
+	e, err := fecWriter(&w, 4, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	e.Write([]byte("abc"))
+	e.Write([]byte("de"))
+	size := w.Len()
+	c.Advance(fecFlush / 2)
+	if w.Len() != size {
+		t.Fatalf("parity sent before the flush delay")
+	}
+	c.Advance(fecFlush)
+	buf := []byte(w.String())[size:]
+	if len(buf) != fecHeaderLen+2+3 || buf[8] != 2 || buf[9] != 2 {
+		t.Fatalf("unexpected parity: %x", buf)
+	}
+	e.Write([]byte("f"))
+	size = w.Len()
+	e.Close()
+	if buf = []byte(w.String())[size:]; len(buf) != fecHeaderLen+2+1 || buf[8] != 1 || buf[9] != 1 {
+		t.Fatalf("unexpected parity on close: %x", buf)
+	}
+}
+
+func TestResequenceHold(t *testing.T) {
+	c := newFakeClock()
+	q := make(packetQueue, 4)
+	r, err := resequenceReader(q, 8, time.Second, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer r.Close()
+	for _, seq := range []uint64{1, 3} {
+		xs, _ := wrapSeq(meta{when: c.Now()}, seq, []byte{byte(seq)})
+		q <- xs
+	}
+	buf := make([]byte, 16)
+	if n, _, err := r.ReadFrom(buf); err != nil || n != 1 || buf[0] != 1 {
+		t.Fatalf("unexpected packet: %x (%v)", buf[:n], err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.ReadFrom(buf)
+	}()
+	waitFor(t, func() bool { return c.pending() == 1 })
+	c.Advance(time.Second / 2)
+	select {
+	case <-done:
+		t.Fatalf("packet released before the hold timeout")
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.Advance(time.Second)
+	<-done
+	if buf[0] != 3 || r.stats().Lost != 1 {
+		t.Fatalf("unexpected packet: %x (%+v)", buf[0], r.stats())
+	}
+}
+
+type packetQueue chan []byte
+
+func (q packetQueue) ReadFrom(xs []byte) (int, net.Addr, error) {
+	return copy(xs, <-q), nil, nil
+}
+
+func (q packetQueue) Close() error {
+	return nil
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(xs []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(xs)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *syncBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func (b *syncBuffer) Close() error {
+	return nil
+}
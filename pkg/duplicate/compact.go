@@ -0,0 +1,144 @@
+package duplicate
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Compaction describes the archive written by CompactArchives.
+type Compaction struct {
+	Target  string
+	Files   int
+	Kept    int
+	Dropped int
+	Before  int64
+	After   int64
+}
+
+// CompactArchives merges the archive files, in the given order, into the first
+// one, keeping only the packets of the given APIDs if any and compressing the
+// result with gzip if asked. The other files are removed.
+func CompactArchives(files []string, apids []string, gz bool) (Compaction, error) {
+	var accept acceptFunc
+	if len(apids) > 0 {
+		rs, err := parseApids(apids)
+		if err != nil {
+			return Compaction{}, err
+		}
+		accept = acceptApids(rs)
+	}
+	return compactFiles(files, accept, gz)
+}
+
+func compactFiles(files []string, accept acceptFunc, gz bool) (Compaction, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(files[0]), ".compact.*")
+	if err != nil {
+		return Compaction{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	var (
+		bw               = bufio.NewWriter(tmp)
+		w      io.Writer = bw
+		zw     *gzip.Writer
+		before int64
+		kept   int
+		drop   int
+	)
+	if gz {
+		zw = gzip.NewWriter(bw)
+		w = zw
+	}
+	for _, f := range files {
+		k, d, n, err := copyArchive(w, f, accept)
+		if err != nil {
+			tmp.Close()
+			return Compaction{}, fmt.Errorf("%s: %w", f, err)
+		}
+		kept, drop, before = kept+k, drop+d, before+n
+	}
+	if zw != nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		return Compaction{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Compaction{}, err
+	}
+
+	target := files[0]
+	if gz {
+		target += ".gz"
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return Compaction{}, err
+	}
+	for _, f := range files {
+		if f != target {
+			os.Remove(f)
+		}
+	}
+	c := Compaction{
+		Target:  target,
+		Files:   len(files),
+		Kept:    kept,
+		Dropped: drop,
+		Before:  before,
+	}
+	if i, err := os.Stat(target); err == nil {
+		c.After = i.Size()
+	}
+	return c, nil
+}
+
+func copyArchive(w io.Writer, file string, accept acceptFunc) (int, int, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+
+	var (
+		rs         = bufio.NewReader(f)
+		xs         = make([]byte, 1<<16)
+		hdr        [4]byte
+		kept, drop int
+		size       int64
+	)
+	if i, err := f.Stat(); err == nil {
+		size = i.Size()
+	}
+	for {
+		n, err := readLength(rs, xs)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return kept, drop, size, err
+		}
+		if accept != nil && !accept(xs[:n]) {
+			drop++
+			continue
+		}
+		binary.BigEndian.PutUint32(hdr[:], uint32(n))
+		if _, err := w.Write(hdr[:]); err != nil {
+			return kept, drop, size, err
+		}
+		if _, err := w.Write(xs[:n]); err != nil {
+			return kept, drop, size, err
+		}
+		kept++
+	}
+	return kept, drop, size, nil
+}
@@ -0,0 +1,94 @@
+package duplicate
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type compressor struct {
+	mu sync.Mutex
+	*gzip.Writer
+	conn io.WriteCloser
+}
+
+func checkCompress(compress string) error {
+	switch compress {
+	case "", "gzip":
+		return nil
+	default:
+		return fmt.Errorf("%s: unsupported compression (available: gzip)", compress)
+	}
+}
+
+func (r Route) checkCompress() error {
+	if err := checkCompress(r.Compress); err != nil {
+		return err
+	}
+	if r.Compress != "" && (r.Proto != "tcp" || r.Listen || r.Share || len(r.Members) > 0) {
+		return fmt.Errorf("compress requires a tcp route")
+	}
+	return nil
+}
+
+func compressWriter(w io.WriteCloser, compress string) (io.WriteCloser, error) {
+	if err := checkCompress(compress); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if compress == "" {
+		return w, nil
+	}
+	c := compressor{
+		Writer: gzip.NewWriter(w),
+		conn:   w,
+	}
+	return &c, nil
+}
+
+func (c *compressor) Write(xs []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.Writer.Write(xs); err != nil {
+		return 0, err
+	}
+	if err := c.Writer.Flush(); err != nil {
+		return 0, err
+	}
+	return len(xs), nil
+}
+
+// Close closes the connection first when a write is in progress, since the
+// write can be stuck on it, before terminating the gzip stream.
+func (c *compressor) Close() error {
+	if !c.mu.TryLock() {
+		err := c.conn.Close()
+		c.mu.Lock()
+		c.Writer.Close()
+		c.mu.Unlock()
+		return err
+	}
+	defer c.mu.Unlock()
+	err := c.Writer.Close()
+	if e := c.conn.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func decompressReader(r io.Reader, compress string) (*bufio.Reader, error) {
+	switch compress {
+	case "":
+		return bufio.NewReaderSize(r, 1<<16), nil
+	case "gzip":
+		z, err := gzip.NewReader(bufio.NewReader(r))
+		if err != nil {
+			return nil, err
+		}
+		return bufio.NewReaderSize(z, 1<<16), nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported compression (available: gzip)", compress)
+	}
+}
@@ -0,0 +1,153 @@
+package duplicate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+
+	"github.com/midbel/toml"
+)
+
+var variable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// LoadConfig reads the configuration from a TOML file, with its includes and
+// its ${VAR} references expanded.
+func LoadConfig(file string) (Config, error) {
+	var c Config
+	if err := toml.DecodeFile(file, &c); err != nil {
+		return c, err
+	}
+	if err := c.include(file); err != nil {
+		return c, err
+	}
+	raw := c
+	if err := expandValue(reflect.ValueOf(&c).Elem()); err != nil {
+		return c, fmt.Errorf("%s: %w", file, err)
+	}
+	c.raw = &raw
+	return c, nil
+}
+
+func (c *Config) include(file string) error {
+	var (
+		routes  = make(map[string]string)
+		tenants = make(map[string]string)
+	)
+	register := func(origin string, rs []Route, ts []Tenant) error {
+		for _, r := range rs {
+			name := r.Name
+			if name == "" {
+				name = r.Addr
+			}
+			if prev, ok := routes[name]; ok && prev != origin {
+				return fmt.Errorf("%s: route %s already defined in %s", origin, name, prev)
+			}
+			routes[name] = origin
+		}
+		for _, t := range ts {
+			if prev, ok := tenants[t.Name]; ok && prev != origin {
+				return fmt.Errorf("%s: tenant %s already defined in %s", origin, t.Name, prev)
+			}
+			tenants[t.Name] = origin
+		}
+		return nil
+	}
+	if err := register(file, append(c.Routes, c.Groups...), c.Tenants); err != nil {
+		return err
+	}
+	for _, pattern := range c.Include {
+		pattern, err := expandString(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(file), pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			var x Config
+			if err := toml.DecodeFile(f, &x); err != nil {
+				return fmt.Errorf("%s: %w", f, err)
+			}
+			if err := register(f, append(x.Routes, x.Groups...), x.Tenants); err != nil {
+				return err
+			}
+			c.Listeners = append(c.Listeners, x.Listeners...)
+			c.Routes = append(c.Routes, x.Routes...)
+			c.Groups = append(c.Groups, x.Groups...)
+			c.Tenants = append(c.Tenants, x.Tenants...)
+
+			x.Listeners, x.Routes, x.Groups, x.Tenants = nil, nil, nil, nil
+			if !reflect.ValueOf(x).IsZero() {
+				return fmt.Errorf("%s: only listener, route, group and tenant tables can be included", f)
+			}
+		}
+	}
+	c.Include = nil
+	return nil
+}
+
+func expandValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		str, err := expandString(v.String())
+		if err == nil {
+			v.SetString(str)
+		}
+		return err
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		p := reflect.New(v.Type().Elem())
+		p.Elem().Set(v.Elem())
+		v.Set(p)
+		return expandValue(v.Elem())
+	case reflect.Slice:
+		// the values are expanded in a copy of the slice to leave untouched
+		// the raw configuration sharing the same array.
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(s, v)
+		v.Set(s)
+		for i := 0; i < v.Len(); i++ {
+			if err := expandValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if err := expandValue(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func expandString(str string) (string, error) {
+	var err error
+	str = variable.ReplaceAllStringFunc(str, func(ref string) string {
+		parts := variable.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(parts[1]); ok {
+			return v
+		}
+		if parts[2] != "" {
+			return parts[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("%s: environment variable not set", ref)
+		}
+		return ref
+	})
+	return str, err
+}
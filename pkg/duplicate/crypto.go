@@ -0,0 +1,125 @@
+package duplicate
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+const noncePrefixLen = 4
+
+// Encryption configures the encryption of the packets sent to a route.
+type Encryption struct {
+	Key  string
+	File string `toml:"key-file"`
+}
+
+func (e Encryption) isSet() bool {
+	return e.Key != "" || e.File != ""
+}
+
+func (e Encryption) aead() (cipher.AEAD, error) {
+	if !e.isSet() {
+		return nil, nil
+	}
+	if e.Key != "" && e.File != "" {
+		return nil, fmt.Errorf("encryption: key and key-file are mutually exclusive")
+	}
+	str := e.Key
+	if e.File != "" {
+		buf, err := os.ReadFile(e.File)
+		if err != nil {
+			return nil, err
+		}
+		str = strings.TrimSpace(string(buf))
+	}
+	key, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: invalid hex key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+type sealer struct {
+	io.WriteCloser
+	aead  cipher.AEAD
+	nonce []byte
+	count uint64
+	buf   []byte
+}
+
+func encryptWriter(w io.WriteCloser, e Encryption) (io.WriteCloser, error) {
+	aead, err := e.aead()
+	if err != nil || aead == nil {
+		return w, err
+	}
+	s := sealer{
+		WriteCloser: w,
+		aead:        aead,
+		nonce:       make([]byte, aead.NonceSize()),
+	}
+	if _, err := rand.Read(s.nonce[:noncePrefixLen]); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *sealer) Write(xs []byte) (int, error) {
+	s.count++
+	binary.BigEndian.PutUint64(s.nonce[noncePrefixLen:], s.count)
+	s.buf = append(s.buf[:0], s.nonce...)
+	s.buf = s.aead.Seal(s.buf, s.nonce, xs, nil)
+	if _, err := s.WriteCloser.Write(s.buf); err != nil {
+		return 0, err
+	}
+	return len(xs), nil
+}
+
+type opener struct {
+	PacketReader
+	aead cipher.AEAD
+	buf  []byte
+}
+
+func decryptReader(r PacketReader, e Encryption) (PacketReader, error) {
+	aead, err := e.aead()
+	if err != nil || aead == nil {
+		return r, err
+	}
+	o := opener{
+		PacketReader: r,
+		aead:         aead,
+		buf:          make([]byte, MaxPacketSize),
+	}
+	return &o, nil
+}
+
+func (o *opener) ReadFrom(xs []byte) (int, net.Addr, error) {
+	n, addr, err := o.PacketReader.ReadFrom(o.buf)
+	if err != nil {
+		return n, addr, err
+	}
+	size := o.aead.NonceSize()
+	if n < size+o.aead.Overhead() {
+		return n, addr, ErrCorrupted
+	}
+	body, err := o.aead.Open(o.buf[size:size], o.buf[:size], o.buf[size:n], nil)
+	if err != nil {
+		return n, addr, ErrCorrupted
+	}
+	if len(body) > len(xs) {
+		return 0, addr, io.ErrShortBuffer
+	}
+	return copy(xs, body), addr, nil
+}
@@ -0,0 +1,13 @@
+// Package duplicate is the forwarding engine of the duplicate command. It
+// receives packets on the listeners of each tenant of a configuration and
+// forwards them to their routes.
+//
+// A program embedding the engine loads a configuration, sets it up and runs it
+// until its context is cancelled:
+//
+//	c, err := duplicate.LoadConfig("duplicate.toml")
+//	if err != nil {
+//		return err
+//	}
+//	return duplicate.New(c).Run(ctx)
+package duplicate
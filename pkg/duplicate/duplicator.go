@@ -0,0 +1,170 @@
+package duplicate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Duplicator forwards the packets received by the tenants of a configuration to
+// their routes.
+type Duplicator struct {
+	config  Config
+	relays  []*relay
+	monitor *monitor
+	clock   clock
+	err     error
+}
+
+// New sets up the tenants of the configuration. The error of the setup, if any,
+// is given by Err and returned by Run.
+func New(c Config) *Duplicator {
+	var d Duplicator
+	d.err = d.setup(c)
+	return &d
+}
+
+// Err gives the error met by New while setting up the tenants.
+func (d *Duplicator) Err() error {
+	return d.err
+}
+
+func (d *Duplicator) setup(c Config) error {
+	ts, err := c.tenants()
+	if err == nil {
+		err = c.Storage.check()
+	}
+	if err != nil {
+		return err
+	}
+	if d.clock, err = c.Clock.clock(); err != nil {
+		return err
+	}
+	c.Recovery.File = c.Storage.resolve(c.Recovery.File)
+	c.PauseFile = c.Storage.resolve(c.PauseFile)
+	for i := range ts {
+		if ts[i], err = c.Storage.tenant(ts[i]); err != nil {
+			return err
+		}
+	}
+	d.config = c
+	d.monitor = newMonitor(c.Resources)
+	for _, t := range ts {
+		r, err := setupRelay(context.Background(), t, d.clock)
+		if err != nil {
+			d.close()
+			return fmt.Errorf("%s: %w", t, err)
+		}
+		d.relays = append(d.relays, r)
+	}
+	return nil
+}
+
+// Run forwards the packets until ctx is cancelled or a tenant fails. On
+// cancellation, the tenants are drained and stopped, and the recovery file is
+// written.
+func (d *Duplicator) Run(ctx context.Context) error {
+	if d.err != nil {
+		return d.err
+	}
+	defer func() {
+		for _, r := range d.relays {
+			r.Stop()
+		}
+	}()
+	// the background tasks stop with ctx and Run waits for them before
+	// returning.
+	var bg sync.WaitGroup
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		bg.Wait()
+	}()
+	goWait := func(fn func()) {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			fn()
+		}()
+	}
+	if d.config.Admin != "" {
+		a := admin{
+			relays:  d.relays,
+			monitor: d.monitor,
+			start:   time.Now(),
+		}
+		if err := a.Serve(d.config.Admin); err != nil {
+			d.close()
+			return err
+		}
+		goWait(func() {
+			<-ctx.Done()
+			a.Close()
+		})
+	}
+	goWait(func() { d.monitor.Run(ctx) })
+	goWait(func() { d.config.Recovery.run(ctx, d.clock, d.config, d.relays) })
+	if d.config.PauseFile != "" {
+		goWait(func() { d.watchPause(ctx, d.config.PauseFile) })
+	}
+	notify("READY=1")
+	goWait(func() { watchdog(ctx) })
+
+	var (
+		done     = make(chan struct{})
+		shutdown = make(chan struct{})
+	)
+	go func() {
+		defer close(shutdown)
+		select {
+		case <-ctx.Done():
+			d.shutdown()
+		case <-done:
+		}
+	}()
+
+	var grp errgroup.Group
+	for _, r := range d.relays {
+		r := r
+		grp.Go(func() error {
+			err := r.Run()
+			if err != nil {
+				cancel()
+			}
+			return err
+		})
+	}
+	err := grp.Wait()
+	close(done)
+	<-shutdown
+	return err
+}
+
+func (d *Duplicator) shutdown() {
+	notify("STOPPING=1")
+	drain, wait := d.config.Shutdown.timeouts()
+	var wg sync.WaitGroup
+	for _, r := range d.relays {
+		wg.Add(1)
+		go func(r *relay) {
+			defer wg.Done()
+			r.Shutdown(drain, wait)
+		}(r)
+	}
+	wg.Wait()
+	if d.config.Recovery.File != "" {
+		if err := d.config.Recovery.write(d.clock, d.config, d.relays); err != nil {
+			log.Printf("recovery: %s", err)
+		}
+	}
+}
+
+func (d *Duplicator) close() {
+	for _, r := range d.relays {
+		r.close()
+	}
+}
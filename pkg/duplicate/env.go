@@ -0,0 +1,66 @@
+package duplicate
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const envPrefix = "DUPLICATE_"
+
+// ConfigFromEnv builds a single tenant configuration from the DUPLICATE_
+// environment variables.
+func ConfigFromEnv() (Config, error) {
+	var (
+		c   Config
+		err error
+	)
+	c.Remote = getenv("REMOTE")
+	c.Ifi = getenv("NIC")
+	c.Proto = getenv("PROTOCOL")
+	c.Network = getenv("NETWORK")
+	c.Framing = getenv("FRAMING")
+	c.Admin = getenv("ADMIN")
+	if c.Remote == "" {
+		return c, fmt.Errorf("%sREMOTE: not set", envPrefix)
+	}
+
+	var r Route
+	if err = getenvValue("DELAY", &r.Delay); err != nil {
+		return c, err
+	}
+	if err = getenvValue("BUFFER", &r.Buffer); err != nil {
+		return c, err
+	}
+	if err = getenvValue("JITTER", &r.Jitter); err != nil {
+		return c, err
+	}
+	r.Proto = getenv("ROUTE_PROTOCOL")
+	for _, a := range strings.Split(getenv("ROUTES"), ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		r.Addr = a
+		c.Routes = append(c.Routes, r)
+	}
+	if len(c.Routes) == 0 {
+		return c, fmt.Errorf("%sROUTES: not set", envPrefix)
+	}
+	return c, nil
+}
+
+func getenv(key string) string {
+	return strings.TrimSpace(os.Getenv(envPrefix + key))
+}
+
+func getenvValue(key string, v flag.Value) error {
+	str := getenv(key)
+	if str == "" {
+		return nil
+	}
+	if err := v.Set(str); err != nil {
+		return fmt.Errorf("%s%s: %w", envPrefix, key, err)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package duplicate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+type meta struct {
+	addr    net.Addr
+	when    time.Time
+	latency time.Duration
+}
+
+func (m meta) source() string {
+	if m.addr == nil {
+		return ""
+	}
+	return m.addr.String()
+}
+
+type wrapFunc func(meta, uint64, []byte) ([]byte, error)
+
+type envelope struct {
+	io.WriteCloser
+	wrap wrapFunc
+	curr *meta
+	seq  uint64
+}
+
+func envelopeWriter(w io.WriteCloser, kind string, curr *meta) (io.WriteCloser, error) {
+	var wrap wrapFunc
+	switch kind {
+	case "":
+		return w, nil
+	case "json":
+		wrap = wrapJSON
+	case "cbor":
+		wrap = wrapCBOR
+	case "seq":
+		wrap = wrapSeq
+	case "proxy":
+		wrap = wrapProxy
+	default:
+		return nil, fmt.Errorf("%s: unsupported envelope", kind)
+	}
+	return &envelope{WriteCloser: w, wrap: wrap, curr: curr}, nil
+}
+
+func (e *envelope) Write(xs []byte) (int, error) {
+	buf, err := e.wrap(*e.curr, e.seq, xs)
+	if err != nil {
+		return 0, err
+	}
+	e.seq++
+	if _, err := e.WriteCloser.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(xs), nil
+}
+
+type record struct {
+	When     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Sequence uint64    `json:"sequence"`
+	Latency  int64     `json:"latency,omitempty"`
+	Payload  []byte    `json:"payload"`
+}
+
+func wrapJSON(m meta, seq uint64, xs []byte) ([]byte, error) {
+	r := record{
+		When:     m.when.UTC(),
+		Source:   m.source(),
+		Sequence: seq,
+		Latency:  m.latency.Microseconds(),
+		Payload:  xs,
+	}
+	return json.Marshal(r)
+}
+
+const (
+	cborUint  = 0 << 5
+	cborBytes = 2 << 5
+	cborText  = 3 << 5
+	cborArray = 4 << 5
+	cborMap   = 5 << 5
+	cborTag   = 6 << 5
+	cborFloat = 7<<5 | 27
+
+	cborEpoch = 1
+)
+
+func wrapCBOR(m meta, seq uint64, xs []byte) ([]byte, error) {
+	fields := uint64(4)
+	if m.latency > 0 {
+		fields++
+	}
+	buf := make([]byte, 0, len(xs)+80)
+	buf = cborHead(buf, cborMap, fields)
+
+	buf = cborString(buf, "time")
+	buf = cborHead(buf, cborTag, cborEpoch)
+	buf = append(buf, cborFloat)
+	when := float64(m.when.UnixNano()) / float64(time.Second)
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(when))
+
+	buf = cborString(buf, "source")
+	buf = cborString(buf, m.source())
+
+	buf = cborString(buf, "sequence")
+	buf = cborHead(buf, cborUint, seq)
+
+	if m.latency > 0 {
+		buf = cborString(buf, "latency")
+		buf = cborHead(buf, cborUint, uint64(m.latency.Microseconds()))
+	}
+
+	buf = cborString(buf, "payload")
+	buf = cborHead(buf, cborBytes, uint64(len(xs)))
+	return append(buf, xs...), nil
+}
+
+func cborString(buf []byte, str string) []byte {
+	buf = cborHead(buf, cborText, uint64(len(str)))
+	return append(buf, str...)
+}
+
+func cborHead(buf []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(buf, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, major|27), n)
+	}
+}
+
+type unwrapFunc func([]byte) ([]byte, error)
+
+type unwrapper struct {
+	PacketReader
+	unwrap unwrapFunc
+	buf    []byte
+}
+
+func unwrapReader(r PacketReader, kind string, window int, hold time.Duration, clk clock) (PacketReader, error) {
+	var unwrap unwrapFunc
+	switch kind {
+	case "":
+		return r, nil
+	case "seq":
+		s, err := resequenceReader(r, window, hold, clk)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "json":
+		unwrap = unwrapJSON
+	case "cbor":
+		unwrap = unwrapCBOR
+	case "proxy":
+		return unwrapProxy(r), nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported envelope", kind)
+	}
+	u := unwrapper{
+		PacketReader: r,
+		unwrap:       unwrap,
+		buf:          make([]byte, MaxPacketSize),
+	}
+	return &u, nil
+}
+
+func (u *unwrapper) ReadFrom(xs []byte) (int, net.Addr, error) {
+	n, addr, err := u.PacketReader.ReadFrom(u.buf)
+	if err != nil {
+		return n, addr, err
+	}
+	body, err := u.unwrap(u.buf[:n])
+	if err != nil {
+		return 0, addr, err
+	}
+	if len(body) > len(xs) {
+		return 0, addr, io.ErrShortBuffer
+	}
+	return copy(xs, body), addr, nil
+}
+
+func unwrapJSON(xs []byte) ([]byte, error) {
+	var r record
+	if err := json.Unmarshal(xs, &r); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	return r.Payload, nil
+}
+
+func unwrapCBOR(xs []byte) ([]byte, error) {
+	major, size, xs, err := cborRead(xs)
+	if err != nil {
+		return nil, err
+	}
+	if major != cborMap {
+		return nil, fmt.Errorf("%w: cbor map expected", ErrInvalid)
+	}
+	for i := uint64(0); i < size; i++ {
+		major, n, rest, err := cborRead(xs)
+		if err != nil {
+			return nil, err
+		}
+		if major != cborText || uint64(len(rest)) < n {
+			return nil, fmt.Errorf("%w: cbor text key expected", ErrInvalid)
+		}
+		key := string(rest[:n])
+		xs = rest[n:]
+		if key != "payload" {
+			if xs, err = cborSkip(xs); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		major, n, rest, err = cborRead(xs)
+		if err != nil {
+			return nil, err
+		}
+		if major != cborBytes || uint64(len(rest)) < n {
+			return nil, fmt.Errorf("%w: cbor payload should be a byte string", ErrInvalid)
+		}
+		return rest[:n], nil
+	}
+	return nil, fmt.Errorf("%w: cbor payload not found", ErrInvalid)
+}
+
+func cborRead(xs []byte) (byte, uint64, []byte, error) {
+	if len(xs) == 0 {
+		return 0, 0, nil, fmt.Errorf("%w: cbor truncated", ErrInvalid)
+	}
+	major, info := xs[0]&0xE0, xs[0]&0x1F
+	xs = xs[1:]
+	if info < 24 {
+		return major, uint64(info), xs, nil
+	}
+	if info > 27 {
+		return 0, 0, nil, fmt.Errorf("%w: cbor indefinite length not supported", ErrInvalid)
+	}
+	size := 1 << (info - 24)
+	if len(xs) < size {
+		return 0, 0, nil, fmt.Errorf("%w: cbor truncated", ErrInvalid)
+	}
+	var n uint64
+	for _, b := range xs[:size] {
+		n = n<<8 | uint64(b)
+	}
+	return major, n, xs[size:], nil
+}
+
+func cborSkip(xs []byte) ([]byte, error) {
+	major, n, xs, err := cborRead(xs)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborBytes, cborText:
+		if uint64(len(xs)) < n {
+			return nil, fmt.Errorf("%w: cbor truncated", ErrInvalid)
+		}
+		return xs[n:], nil
+	case cborArray, cborMap:
+		if major == cborMap {
+			n *= 2
+		}
+		for i := uint64(0); i < n; i++ {
+			if xs, err = cborSkip(xs); err != nil {
+				return nil, err
+			}
+		}
+		return xs, nil
+	case cborTag:
+		return cborSkip(xs)
+	default:
+		return xs, nil
+	}
+}
@@ -0,0 +1,121 @@
+package duplicate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	execPrefix  = "exec:"
+	execTimeout = 5 * time.Second
+)
+
+type process struct {
+	io.WriteCloser
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	done  chan struct{}
+	once  sync.Once
+}
+
+func execCommand(transform string) ([]string, error) {
+	args := strings.Fields(strings.TrimPrefix(transform, execPrefix))
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: command expected", transform)
+	}
+	return args, nil
+}
+
+func checkTransform(transform string) error {
+	if !strings.HasPrefix(transform, execPrefix) {
+		_, err := transformWriter(nil, transform, wallClock{})
+		return err
+	}
+	args, err := execCommand(transform)
+	if err != nil {
+		return err
+	}
+	_, err = exec.LookPath(args[0])
+	return err
+}
+
+func execWriter(w io.WriteCloser, transform string) (io.WriteCloser, error) {
+	args, err := execCommand(transform)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := process{
+		WriteCloser: w,
+		cmd:         cmd,
+		stdin:       lengthWriter{WriteCloser: stdin},
+		done:        make(chan struct{}),
+	}
+	go p.forward(stdout)
+	return &p, nil
+}
+
+func (p *process) forward(r io.Reader) {
+	defer close(p.done)
+	var (
+		rs  = bufio.NewReader(r)
+		buf = make([]byte, MaxPacketSize)
+	)
+	for {
+		n, err := readLength(rs, buf)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				io.Copy(io.Discard, rs)
+			}
+			return
+		}
+		p.WriteCloser.Write(buf[:n])
+	}
+}
+
+func (p *process) Write(xs []byte) (int, error) {
+	select {
+	case <-p.done:
+		return 0, fmt.Errorf("%s: transform exited", p.cmd.Path)
+	default:
+	}
+	return p.stdin.Write(xs)
+}
+
+func (p *process) Close() error {
+	var err error
+	p.once.Do(func() {
+		p.stdin.Close()
+		select {
+		case <-p.done:
+		case <-time.After(execTimeout):
+			p.cmd.Process.Kill()
+			<-p.done
+		}
+		err = p.cmd.Wait()
+		if e := p.WriteCloser.Close(); err == nil {
+			err = e
+		}
+	})
+	return err
+}
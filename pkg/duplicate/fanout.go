@@ -0,0 +1,157 @@
+package duplicate
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultBacklog = 1024
+
+type clientStats struct {
+	Connected int    `json:"connected"`
+	Evicted   uint64 `json:"evicted"`
+}
+
+type client struct {
+	net.Conn
+	queue chan []byte
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (c *client) evict() {
+	c.once.Do(func() {
+		close(c.done)
+		c.Conn.Close()
+	})
+}
+
+type fanout struct {
+	net.Listener
+	tls     *tls.Config
+	backlog int
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	closed  bool
+	evicted uint64
+}
+
+func listenRoute(r Route) (*fanout, error) {
+	if r.Proto != "" && r.Proto != "tcp" {
+		return nil, errors.New("only tcp routes can listen for clients")
+	}
+	cfg, err := r.Certificate.Server()
+	if err != nil {
+		return nil, err
+	}
+	s, err := net.Listen("tcp", r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	f := fanout{
+		Listener: s,
+		tls:      cfg,
+		backlog:  r.Backlog,
+		clients:  make(map[*client]struct{}),
+	}
+	if f.backlog <= 0 {
+		f.backlog = DefaultBacklog
+	}
+	go f.serve()
+	return &f, nil
+}
+
+func (f *fanout) serve() {
+	for {
+		c, err := f.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		if f.tls != nil {
+			c = tls.Server(c, f.tls)
+		}
+		x := client{
+			Conn:  c,
+			queue: make(chan []byte, f.backlog),
+			done:  make(chan struct{}),
+		}
+		f.mu.Lock()
+		if f.closed {
+			f.mu.Unlock()
+			c.Close()
+			return
+		}
+		f.clients[&x] = struct{}{}
+		f.mu.Unlock()
+
+		go f.send(&x)
+	}
+}
+
+func (f *fanout) send(c *client) {
+	defer f.remove(c)
+	for {
+		select {
+		case xs := <-c.queue:
+			if _, err := c.Write(xs); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (f *fanout) remove(c *client) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.clients, c)
+	c.evict()
+}
+
+func (f *fanout) Write(xs []byte) (int, error) {
+	buf := append([]byte(nil), xs...)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.clients {
+		select {
+		case c.queue <- buf:
+		default:
+			delete(f.clients, c)
+			atomic.AddUint64(&f.evicted, 1)
+			c.evict()
+		}
+	}
+	return len(xs), nil
+}
+
+func (f *fanout) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	for c := range f.clients {
+		delete(f.clients, c)
+		c.evict()
+	}
+	return f.Listener.Close()
+}
+
+func (f *fanout) stats() *clientStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &clientStats{
+		Connected: len(f.clients),
+		Evicted:   atomic.LoadUint64(&f.evicted),
+	}
+}
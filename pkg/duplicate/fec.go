@@ -0,0 +1,292 @@
+package duplicate
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	fecHeaderLen = 10
+	fecMaxGroup  = 64
+	fecBlocks    = 32
+	fecFlush     = 50 * time.Millisecond
+)
+
+// fecEncoder sends a parity packet after each group of packets. The parity of
+// a block that is not complete after a short while, or when the route is
+// closed, is sent anyway: its index and its group give the number of packets
+// of the block.
+type fecEncoder struct {
+	io.WriteCloser
+	group   int
+	session uint32
+	clock   clock
+
+	mu     sync.Mutex
+	block  uint32
+	index  int
+	parity []byte
+	size   uint16
+	buf    []byte
+	timer  *timer
+}
+
+func checkFEC(group int) error {
+	if group != 0 && (group < 2 || group > fecMaxGroup) {
+		return fmt.Errorf("fec: group size should be between 2 and %d", fecMaxGroup)
+	}
+	return nil
+}
+
+func fecWriter(w io.WriteCloser, group int, clk clock) (io.WriteCloser, error) {
+	if err := checkFEC(group); err != nil || group == 0 {
+		return w, err
+	}
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	e := fecEncoder{
+		WriteCloser: w,
+		group:       group,
+		session:     binary.BigEndian.Uint32(id[:]),
+		clock:       clk,
+	}
+	return &e, nil
+}
+
+func (e *fecEncoder) Write(xs []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.emit(e.index, e.group, xs); err != nil {
+		return 0, err
+	}
+	e.size ^= uint16(len(xs))
+	if len(e.parity) < len(xs) {
+		e.parity = append(e.parity, make([]byte, len(xs)-len(e.parity))...)
+	}
+	for i := range xs {
+		e.parity[i] ^= xs[i]
+	}
+	if e.index++; e.index < e.group {
+		if e.index == 1 {
+			e.schedule()
+		}
+		return len(xs), nil
+	}
+	if err := e.flush(); err != nil {
+		return 0, err
+	}
+	return len(xs), nil
+}
+
+func (e *fecEncoder) schedule() {
+	if e.timer == nil {
+		e.timer = e.clock.AfterFunc(fecFlush, func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.flush()
+		})
+		return
+	}
+	e.timer.Reset(fecFlush)
+}
+
+// flush sends the parity of the current block, if any.
+func (e *fecEncoder) flush() error {
+	if e.index == 0 {
+		return nil
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	parity := binary.BigEndian.AppendUint16(nil, e.size)
+	err := e.emit(e.index, e.index, append(parity, e.parity...))
+
+	e.block++
+	e.index, e.size, e.parity = 0, 0, e.parity[:0]
+	return err
+}
+
+func (e *fecEncoder) Close() error {
+	e.mu.Lock()
+	err := e.flush()
+	e.mu.Unlock()
+	if e := e.WriteCloser.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (e *fecEncoder) emit(index, group int, xs []byte) error {
+	e.buf = binary.BigEndian.AppendUint32(e.buf[:0], e.session)
+	e.buf = binary.BigEndian.AppendUint32(e.buf, e.block)
+	e.buf = append(e.buf, byte(index), byte(group))
+	e.buf = append(e.buf, xs...)
+	_, err := e.WriteCloser.Write(e.buf)
+	return err
+}
+
+type fecStats struct {
+	Recovered uint64 `json:"recovered"`
+	Lost      uint64 `json:"lost"`
+}
+
+type fecBlock struct {
+	group  int
+	count  int
+	data   [][]byte
+	parity []byte
+	done   bool
+}
+
+type fecKey struct {
+	session uint32
+	block   uint32
+}
+
+type fecDecoder struct {
+	PacketReader
+	buf    []byte
+	blocks map[fecKey]*fecBlock
+	order  []fecKey
+	ready  []byte
+	addr   net.Addr
+	stats  *fecStats
+}
+
+func fecReader(r PacketReader, stats *fecStats) PacketReader {
+	if stats == nil {
+		return r
+	}
+	return &fecDecoder{
+		PacketReader: r,
+		buf:          make([]byte, MaxPacketSize),
+		blocks:       make(map[fecKey]*fecBlock),
+		stats:        stats,
+	}
+}
+
+func (d *fecDecoder) ReadFrom(xs []byte) (int, net.Addr, error) {
+	if d.ready != nil {
+		body := d.ready
+		d.ready = nil
+		return d.deliver(xs, body, d.addr)
+	}
+	for {
+		n, addr, err := d.PacketReader.ReadFrom(d.buf)
+		if err != nil {
+			return n, addr, err
+		}
+		if n < fecHeaderLen {
+			return n, addr, ErrCorrupted
+		}
+		var (
+			key = fecKey{
+				session: binary.BigEndian.Uint32(d.buf),
+				block:   binary.BigEndian.Uint32(d.buf[4:]),
+			}
+			index = int(d.buf[8])
+			group = int(d.buf[9])
+			body  = d.buf[fecHeaderLen:n]
+		)
+		if group < 1 || group > fecMaxGroup || index > group || (index < group && group < 2) {
+			return n, addr, ErrCorrupted
+		}
+		var (
+			b      = d.block(key, group)
+			parity = index == group
+		)
+		if parity && group < b.group {
+			// parity of a partial block
+			b.group = group
+		}
+		if b.done || (!parity && (index >= b.group || b.data[index] != nil)) || (parity && b.parity != nil) {
+			continue
+		}
+		if parity {
+			b.parity = append([]byte(nil), body...)
+		} else {
+			b.data[index] = append([]byte(nil), body...)
+			b.count++
+		}
+		if b.count == b.group {
+			b.done = true
+		} else if b.count == b.group-1 && b.parity != nil {
+			b.done = true
+			if d.ready = b.recover(); d.ready != nil {
+				d.addr = addr
+				atomic.AddUint64(&d.stats.Recovered, 1)
+			}
+		}
+		if parity {
+			if d.ready == nil {
+				continue
+			}
+			body, d.ready = d.ready, nil
+		}
+		return d.deliver(xs, body, addr)
+	}
+}
+
+func (d *fecDecoder) block(key fecKey, group int) *fecBlock {
+	if b, ok := d.blocks[key]; ok {
+		return b
+	}
+	if len(d.order) >= fecBlocks {
+		old := d.order[0]
+		if b := d.blocks[old]; !b.done {
+			atomic.AddUint64(&d.stats.Lost, uint64(b.group-b.count))
+		}
+		delete(d.blocks, old)
+		d.order = d.order[1:]
+	}
+	b := fecBlock{
+		group: group,
+		data:  make([][]byte, group),
+	}
+	d.blocks[key] = &b
+	d.order = append(d.order, key)
+	return &b
+}
+
+func (b *fecBlock) recover() []byte {
+	if len(b.parity) < 2 {
+		return nil
+	}
+	size := binary.BigEndian.Uint16(b.parity)
+	xs := append([]byte(nil), b.parity[2:]...)
+	for _, d := range b.data {
+		if len(d) > len(xs) {
+			return nil
+		}
+		size ^= uint16(len(d))
+		for i := range d {
+			xs[i] ^= d[i]
+		}
+	}
+	if int(size) > len(xs) {
+		return nil
+	}
+	return xs[:size]
+}
+
+func (d *fecDecoder) deliver(xs, body []byte, addr net.Addr) (int, net.Addr, error) {
+	if len(body) > len(xs) {
+		return 0, addr, io.ErrShortBuffer
+	}
+	return copy(xs, body), addr, nil
+}
+
+func (s *fecStats) load() *fecStats {
+	return &fecStats{
+		Recovered: atomic.LoadUint64(&s.Recovered),
+		Lost:      atomic.LoadUint64(&s.Lost),
+	}
+}
@@ -0,0 +1,87 @@
+package duplicate
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type acceptFunc func([]byte) bool
+
+type filter struct {
+	io.WriteCloser
+	accept  acceptFunc
+	skipped *counter
+}
+
+func (f *filter) Write(xs []byte) (int, error) {
+	if !f.accept(xs) {
+		f.skipped.count(len(xs))
+		return len(xs), nil
+	}
+	return f.WriteCloser.Write(xs)
+}
+
+func acceptAll(fs []acceptFunc) acceptFunc {
+	if len(fs) == 1 {
+		return fs[0]
+	}
+	return func(xs []byte) bool {
+		for _, f := range fs {
+			if !f(xs) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func acceptSize(min, max int) acceptFunc {
+	return func(xs []byte) bool {
+		if min > 0 && len(xs) < min {
+			return false
+		}
+		return max <= 0 || len(xs) <= max
+	}
+}
+
+type apidRange struct {
+	first uint16
+	last  uint16
+}
+
+func parseApids(vs []string) ([]apidRange, error) {
+	var rs []apidRange
+	for _, v := range vs {
+		first, last := v, v
+		if i := strings.Index(v, "-"); i >= 0 {
+			first, last = v[:i], v[i+1:]
+		}
+		f, err := strconv.ParseUint(strings.TrimSpace(first), 10, 11)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid apid", v)
+		}
+		l, err := strconv.ParseUint(strings.TrimSpace(last), 10, 11)
+		if err != nil || l < f {
+			return nil, fmt.Errorf("%s: invalid apid", v)
+		}
+		rs = append(rs, apidRange{first: uint16(f), last: uint16(l)})
+	}
+	return rs, nil
+}
+
+func acceptApids(rs []apidRange) acceptFunc {
+	return func(xs []byte) bool {
+		if len(xs) < ccsdsHeaderLen {
+			return false
+		}
+		apid := uint16(xs[0]&0x07)<<8 | uint16(xs[1])
+		for _, r := range rs {
+			if apid >= r.first && apid <= r.last {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,310 @@
+package duplicate
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrInvalid is returned for the packets that can not be decoded.
+var ErrInvalid = errors.New("invalid packet")
+
+const (
+	ccsdsHeaderLen = 6
+	// MaxPacketSize is the size of the largest ccsds packet: the header
+	// followed by a data field of up to 65536 bytes.
+	MaxPacketSize = ccsdsHeaderLen + 1<<16
+)
+
+type splitFunc func(*bufio.Reader, []byte) (int, error)
+
+func framer(framing string) (splitFunc, error) {
+	switch framing {
+	case "":
+		return readChunk, nil
+	case "ccsds":
+		return readCCSDS, nil
+	case "length":
+		return readLength, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported framing", framing)
+	}
+}
+
+func readChunk(rs *bufio.Reader, xs []byte) (int, error) {
+	return rs.Read(xs)
+}
+
+func readCCSDS(rs *bufio.Reader, xs []byte) (int, error) {
+	hdr, err := rs.Peek(ccsdsHeaderLen)
+	if err != nil {
+		return 0, err
+	}
+	if version := hdr[0] >> 5; version != 0 {
+		return 0, fmt.Errorf("%w: ccsds version %d", ErrInvalid, version)
+	}
+	size := ccsdsHeaderLen + int(binary.BigEndian.Uint16(hdr[4:])) + 1
+	if size > len(xs) {
+		return 0, io.ErrShortBuffer
+	}
+	return io.ReadFull(rs, xs[:size])
+}
+
+func readLength(rs *bufio.Reader, xs []byte) (int, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(rs, hdr[:]); err != nil {
+		return 0, err
+	}
+	size := int(binary.BigEndian.Uint32(hdr[:]))
+	if size > len(xs) {
+		return 0, io.ErrShortBuffer
+	}
+	return io.ReadFull(rs, xs[:size])
+}
+
+type lengthWriter struct {
+	io.WriteCloser
+}
+
+func (w lengthWriter) Write(xs []byte) (int, error) {
+	buf := getPacket(4 + len(xs))
+	defer putPacket(buf)
+	binary.BigEndian.PutUint32(*buf, uint32(len(xs)))
+	copy((*buf)[4:], xs)
+	if _, err := w.WriteCloser.Write(*buf); err != nil {
+		return 0, err
+	}
+	return len(xs), nil
+}
+
+type tcpListener struct {
+	net.Listener
+	split      splitFunc
+	tls        *tls.Config
+	detect     bool
+	decompress string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	rs     *bufio.Reader
+	closed bool
+}
+
+func listenTCP(l Listener, cert Certificate) (PacketReader, error) {
+	split, err := framer(l.Framing)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := cert.Server()
+	if err != nil {
+		return nil, err
+	}
+	n, err := network("tcp", l.Network)
+	if err != nil {
+		return nil, err
+	}
+	s := inheritTCP(l.Remote)
+	if s == nil {
+		if s, err = net.Listen(n, l.Remote); err != nil {
+			return nil, err
+		}
+	}
+	if l.Concurrent {
+		return serveTCP(s, split, cfg, l.Detect, l.MaxConns, l.Decompress), nil
+	}
+	t := tcpListener{
+		Listener:   s,
+		split:      split,
+		tls:        cfg,
+		detect:     l.Detect,
+		decompress: l.Decompress,
+	}
+	return &t, nil
+}
+
+func (t *tcpListener) ReadFrom(xs []byte) (int, net.Addr, error) {
+	c, rs, err := t.current()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := t.split(rs, xs)
+	if err != nil {
+		t.mu.Lock()
+		t.conn = nil
+		t.mu.Unlock()
+		c.Close()
+	}
+	return n, c.RemoteAddr(), err
+}
+
+func (t *tcpListener) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	return t.Listener.Close()
+}
+
+func (t *tcpListener) upgrade(c net.Conn) (net.Conn, error) {
+	u, err := upgradeConn(c, t.tls, t.detect)
+	if err != nil {
+		c.Close()
+	}
+	return u, err
+}
+
+func (t *tcpListener) current() (net.Conn, *bufio.Reader, error) {
+	t.mu.Lock()
+	c, rs := t.conn, t.rs
+	t.mu.Unlock()
+	if c != nil {
+		return c, rs, nil
+	}
+
+	c, err := t.Accept()
+	if err != nil {
+		return nil, nil, err
+	}
+	if c, err = t.upgrade(c); err != nil {
+		return nil, nil, err
+	}
+	if rs, err = decompressReader(c, t.decompress); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		c.Close()
+		return nil, nil, net.ErrClosed
+	}
+	t.conn, t.rs = c, rs
+	return c, rs, nil
+}
+
+type tcpServer struct {
+	funnel
+	net.Listener
+	split      splitFunc
+	tls        *tls.Config
+	detect     bool
+	decompress string
+	slots      chan struct{}
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func serveTCP(s net.Listener, split splitFunc, cfg *tls.Config, detect bool, max int, decompress string) *tcpServer {
+	t := tcpServer{
+		funnel:     makeFunnel(DefaultQueueSize),
+		Listener:   s,
+		split:      split,
+		tls:        cfg,
+		detect:     detect,
+		decompress: decompress,
+		conns:      make(map[net.Conn]struct{}),
+	}
+	if max > 0 {
+		t.slots = make(chan struct{}, max)
+	}
+	go t.serve()
+	return &t
+}
+
+func (t *tcpServer) Close() error {
+	err := net.ErrClosed
+	t.stop(func() {
+		err = t.Listener.Close()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for c := range t.conns {
+			c.Close()
+		}
+	})
+	return err
+}
+
+func (t *tcpServer) serve() {
+	for {
+		c, err := t.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		if t.slots != nil {
+			select {
+			case t.slots <- struct{}{}:
+			default:
+				c.Close()
+				continue
+			}
+		}
+		go t.handle(c)
+	}
+}
+
+func (t *tcpServer) handle(c net.Conn) {
+	defer func() {
+		if t.slots != nil {
+			<-t.slots
+		}
+	}()
+	if !t.track(c) {
+		c.Close()
+		return
+	}
+	defer t.untrack(c)
+
+	u, err := upgradeConn(c, t.tls, t.detect)
+	if err != nil {
+		return
+	}
+	rs, err := decompressReader(u, t.decompress)
+	if err != nil {
+		return
+	}
+	var (
+		xs   = make([]byte, MaxPacketSize)
+		addr = c.RemoteAddr()
+	)
+	for {
+		n, err := t.split(rs, xs)
+		if err != nil {
+			return
+		}
+		if !t.push(xs[:n], addr, time.Time{}) {
+			return
+		}
+	}
+}
+
+func (t *tcpServer) track(c net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.done:
+		return false
+	default:
+	}
+	t.conns[c] = struct{}{}
+	return true
+}
+
+func (t *tcpServer) untrack(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, c)
+	c.Close()
+}
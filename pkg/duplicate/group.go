@@ -0,0 +1,167 @@
+package duplicate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultGroupRetry = 5 * time.Second
+
+// ErrNoMember is returned by a group route when none of its members can take
+// the packet.
+var ErrNoMember = errors.New("no member available")
+
+func (t Tenant) routes() []Route {
+	rs := make([]Route, 0, len(t.Routes)+len(t.Groups))
+	rs = append(rs, t.Routes...)
+	return append(rs, t.Groups...)
+}
+
+type member struct {
+	io.WriteCloser
+	name  string
+	down  int64
+	probe *prober
+}
+
+func (m *member) available(now time.Time) bool {
+	if m.probe != nil && m.probe.down() {
+		return false
+	}
+	return now.UnixNano() >= atomic.LoadInt64(&m.down)
+}
+
+type memberStats struct {
+	Name      string      `json:"name"`
+	Available bool        `json:"available"`
+	Remote    *probeStats `json:"remote,omitempty"`
+}
+
+type group struct {
+	name    string
+	policy  string
+	members []*member
+	next    uint64
+	logger  *log.Logger
+	clock   clock
+}
+
+// dialGroup opens the routes of the group, each wrapped with the writers of its
+// own options (mtu, heartbeat, simulate, tap, ...).
+func dialGroup(rt *route, logger *log.Logger) (io.WriteCloser, error) {
+	r := rt.Route
+	switch r.Policy {
+	case "", "all", "failover", "round-robin":
+	default:
+		return nil, fmt.Errorf("%s: unsupported group policy", r.Policy)
+	}
+	g := group{
+		name:   r.Name,
+		policy: r.Policy,
+		logger: logger,
+		clock:  rt.clock,
+	}
+	for _, m := range r.Members {
+		if m.Name == "" {
+			m.Name = m.Addr
+		}
+		w, _, err := open(m, rt.clock, logger)
+		if err == nil {
+			mt := route{Route: m, chunked: rt.chunked, clock: rt.clock}
+			w, err = routeWriter(&mt, w, logger)
+		}
+		if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("%s: %w", m.Name, err)
+		}
+		x := member{
+			WriteCloser: w,
+			name:        m.Name,
+		}
+		g.members = append(g.members, &x)
+		if !m.Probe.isSet() {
+			continue
+		}
+		if x.probe, err = newProber(m, rt.clock, logger); err != nil {
+			g.Close()
+			return nil, fmt.Errorf("%s: %w", m.Name, err)
+		}
+	}
+	if len(g.members) == 0 {
+		return nil, fmt.Errorf("group without route")
+	}
+	return &g, nil
+}
+
+func (g *group) Write(xs []byte) (int, error) {
+	switch g.policy {
+	case "failover":
+		return g.writeFirst(xs, 0)
+	case "round-robin":
+		next := atomic.AddUint64(&g.next, 1) - 1
+		return g.writeFirst(xs, int(next%uint64(len(g.members))))
+	default:
+		return g.writeAll(xs)
+	}
+}
+
+func (g *group) writeAll(xs []byte) (int, error) {
+	var err error
+	for _, m := range g.members {
+		if _, e := m.Write(xs); e != nil {
+			err = e
+		}
+	}
+	return len(xs), err
+}
+
+func (g *group) writeFirst(xs []byte, first int) (int, error) {
+	now := g.clock.Now()
+	for i := 0; i < len(g.members); i++ {
+		m := g.members[(first+i)%len(g.members)]
+		if !m.available(now) {
+			continue
+		}
+		if _, err := m.Write(xs); err != nil {
+			atomic.StoreInt64(&m.down, now.Add(DefaultGroupRetry).UnixNano())
+			g.logger.Printf("%s: %s: %s (retrying in %s)", g.name, m.name, err, DefaultGroupRetry)
+			continue
+		}
+		return len(xs), nil
+	}
+	return 0, ErrNoMember
+}
+
+func (g *group) Close() error {
+	var err error
+	for _, m := range g.members {
+		if m.probe != nil {
+			m.probe.Close()
+		}
+		if e := m.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (g *group) stats() []memberStats {
+	var (
+		now = g.clock.Now()
+		ms  = make([]memberStats, len(g.members))
+	)
+	for i, m := range g.members {
+		ms[i] = memberStats{
+			Name:      m.name,
+			Available: m.available(now),
+		}
+		if m.probe != nil {
+			ms[i].Remote = m.probe.stats()
+		}
+	}
+	return ms
+}
@@ -0,0 +1,18 @@
+package duplicate
+
+import "fmt"
+
+const gsoMaxSegment = 64
+
+func (r Route) checkGSO() error {
+	if r.GSO == 0 {
+		return nil
+	}
+	if r.GSO < 2 || r.GSO > gsoMaxSegment {
+		return fmt.Errorf("gso: number of segments should be between 2 and %d", gsoMaxSegment)
+	}
+	if (r.Proto != "" && r.Proto != DefaultProtocol) || r.Listen || r.Share || len(r.Members) > 0 {
+		return fmt.Errorf("gso: only supported by udp routes")
+	}
+	return nil
+}
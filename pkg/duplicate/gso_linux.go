@@ -0,0 +1,186 @@
+//go:build linux
+
+package duplicate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	solUDP     = 17
+	udpSegment = 103
+	udpGRO     = 104
+	gsoMaxSize = 65507
+	gsoFlush   = time.Millisecond
+)
+
+type gsoBatch struct {
+	*net.UDPConn
+	max int
+	oob []byte
+
+	mu       sync.Mutex
+	buf      []byte
+	size     int
+	count    int
+	timer    *time.Timer
+	disabled bool
+	err      error
+}
+
+func gsoWriter(c *net.UDPConn, max int) (io.WriteCloser, error) {
+	b := gsoBatch{
+		UDPConn: c,
+		max:     max,
+		oob:     make([]byte, syscall.CmsgSpace(2)),
+		buf:     make([]byte, 0, gsoMaxSize),
+	}
+	return &b, nil
+}
+
+func (b *gsoBatch) Write(xs []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.disabled {
+		return b.UDPConn.Write(xs)
+	}
+	if b.count > 0 && (len(xs) > b.size || len(b.buf)+len(xs) > gsoMaxSize) {
+		b.flush()
+	}
+	if b.count == 0 {
+		b.size = len(xs)
+	}
+	b.buf = append(b.buf, xs...)
+	b.count++
+	if b.count >= b.max || len(xs) < b.size {
+		b.flush()
+	} else if b.count == 1 {
+		b.schedule()
+	}
+	if err := b.err; err != nil {
+		b.err = nil
+		return 0, err
+	}
+	return len(xs), nil
+}
+
+func (b *gsoBatch) schedule() {
+	if b.timer == nil {
+		b.timer = time.AfterFunc(gsoFlush, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.flush()
+		})
+		return
+	}
+	b.timer.Reset(gsoFlush)
+}
+
+func (b *gsoBatch) flush() {
+	if b.count == 0 {
+		return
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	defer func() {
+		b.buf, b.count = b.buf[:0], 0
+	}()
+	if b.count == 1 {
+		_, b.err = b.UDPConn.Write(b.buf)
+		return
+	}
+	gsoSegment(b.oob, b.size)
+	_, _, err := b.WriteMsgUDP(b.buf, b.oob, nil)
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOPROTOOPT) || errors.Is(err, syscall.EIO) {
+		b.disabled = true
+		for xs := b.buf; len(xs) > 0; {
+			n := min(b.size, len(xs))
+			if _, err = b.UDPConn.Write(xs[:n]); err != nil {
+				break
+			}
+			xs = xs[n:]
+		}
+	}
+	b.err = err
+}
+
+func (b *gsoBatch) Close() error {
+	b.mu.Lock()
+	b.flush()
+	b.mu.Unlock()
+	return b.UDPConn.Close()
+}
+
+// gsoSegment encodes a control message with the size of the segments of the
+// datagram: a struct cmsghdr whose length is a size_t followed by the level
+// and the type, then an uint16.
+func gsoSegment(oob []byte, size int) {
+	offset := strconv.IntSize / 8
+	if offset == 8 {
+		binary.NativeEndian.PutUint64(oob, uint64(syscall.CmsgLen(2)))
+	} else {
+		binary.NativeEndian.PutUint32(oob, uint32(syscall.CmsgLen(2)))
+	}
+	binary.NativeEndian.PutUint32(oob[offset:], solUDP)
+	binary.NativeEndian.PutUint32(oob[offset+4:], udpSegment)
+	binary.NativeEndian.PutUint16(oob[syscall.CmsgLen(0):], uint16(size))
+}
+
+type groReader struct {
+	*net.UDPConn
+	buf  []byte
+	oob  []byte
+	rest []byte
+	size int
+	addr net.Addr
+}
+
+func receiveGRO(c *net.UDPConn) (PacketReader, error) {
+	if err := setSockopts(c, []sockopt{setInt(solUDP, udpGRO, 1)}); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("gro: %w", err)
+	}
+	r := groReader{
+		UDPConn: c,
+		buf:     make([]byte, 1<<16),
+		oob:     make([]byte, syscall.CmsgSpace(4)),
+	}
+	return &r, nil
+}
+
+func (r *groReader) ReadFrom(xs []byte) (int, net.Addr, error) {
+	if len(r.rest) == 0 {
+		n, oobn, _, addr, err := r.ReadMsgUDP(r.buf, r.oob)
+		if err != nil {
+			return 0, addr, err
+		}
+		r.rest, r.size, r.addr = r.buf[:n], n, addr
+		if ms, err := syscall.ParseSocketControlMessage(r.oob[:oobn]); err == nil {
+			for _, m := range ms {
+				if m.Header.Level == solUDP && m.Header.Type == udpGRO && len(m.Data) >= 4 {
+					r.size = int(binary.NativeEndian.Uint32(m.Data))
+				}
+			}
+		}
+		if r.size <= 0 {
+			r.size = n
+		}
+	}
+	n := min(r.size, len(r.rest))
+	if n > len(xs) {
+		r.rest = nil
+		return 0, r.addr, io.ErrShortBuffer
+	}
+	copy(xs, r.rest[:n])
+	r.rest = r.rest[n:]
+	return n, r.addr, nil
+}
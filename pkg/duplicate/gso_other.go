@@ -0,0 +1,19 @@
+//go:build !linux
+
+package duplicate
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+func gsoWriter(c *net.UDPConn, max int) (io.WriteCloser, error) {
+	c.Close()
+	return nil, fmt.Errorf("gso: %w", ErrUnsupported)
+}
+
+func receiveGRO(c *net.UDPConn) (PacketReader, error) {
+	c.Close()
+	return nil, fmt.Errorf("gro: %w", ErrUnsupported)
+}
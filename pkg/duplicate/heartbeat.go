@@ -0,0 +1,108 @@
+package duplicate
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeat configures the packets sent to an idle route.
+type Heartbeat struct {
+	Interval Duration `json:"interval,omitempty"`
+	Payload  string   `json:"payload,omitempty"`
+	Hex      string   `json:"hex,omitempty"`
+}
+
+func (h Heartbeat) isSet() bool {
+	return h.Interval.isSet()
+}
+
+func (h Heartbeat) payload() ([]byte, error) {
+	if h.Hex == "" {
+		return []byte(h.Payload), nil
+	}
+	xs, err := hex.DecodeString(h.Hex)
+	if err != nil {
+		return nil, fmt.Errorf("heartbeat: invalid hex payload: %w", err)
+	}
+	return xs, nil
+}
+
+type heartbeat struct {
+	io.WriteCloser
+	payload []byte
+	every   time.Duration
+	clock   clock
+
+	mu    sync.Mutex
+	last  time.Time
+	count uint64
+
+	once sync.Once
+	done chan struct{}
+}
+
+func heartbeatWriter(w io.WriteCloser, h Heartbeat, clk clock) (*heartbeat, error) {
+	xs, err := h.payload()
+	if err != nil {
+		return nil, err
+	}
+	b := heartbeat{
+		WriteCloser: w,
+		payload:     xs,
+		every:       h.Interval.In(time.Second),
+		clock:       clk,
+		last:        clk.Now(),
+		done:        make(chan struct{}),
+	}
+	go b.run()
+	return &b, nil
+}
+
+func (h *heartbeat) Write(xs []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = h.clock.Now()
+	return h.WriteCloser.Write(xs)
+}
+
+func (h *heartbeat) run() {
+	tick := h.clock.NewTicker(h.every / 4)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			h.beat()
+		case <-h.done:
+			return
+		}
+	}
+}
+
+func (h *heartbeat) beat() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clock.Since(h.last) < h.every {
+		return
+	}
+	h.last = h.clock.Now()
+	if _, err := h.WriteCloser.Write(h.payload); err == nil {
+		atomic.AddUint64(&h.count, 1)
+	}
+}
+
+func (h *heartbeat) Close() error {
+	h.once.Do(func() {
+		close(h.done)
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.WriteCloser.Close()
+}
+
+func (h *heartbeat) sent() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
@@ -0,0 +1,108 @@
+package duplicate
+
+import (
+	"sync"
+	"time"
+)
+
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	20 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+}
+
+type bucket struct {
+	Upper string `json:"le"`
+	Count uint64 `json:"count"`
+}
+
+type summary struct {
+	Count   uint64   `json:"count"`
+	Early   uint64   `json:"early"`
+	Min     string   `json:"min"`
+	Max     string   `json:"max"`
+	Mean    string   `json:"mean"`
+	Buckets []bucket `json:"buckets"`
+}
+
+type histogram struct {
+	mu sync.Mutex
+
+	expected time.Duration
+	counts   []uint64
+	count    uint64
+	early    uint64
+	min      time.Duration
+	max      time.Duration
+	sum      time.Duration
+}
+
+func newHistogram(expected time.Duration) *histogram {
+	return &histogram{
+		expected: expected,
+		counts:   make([]uint64, len(latencyBuckets)+1),
+	}
+}
+
+func (h *histogram) Observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	if d < h.expected {
+		h.early++
+	}
+	h.count++
+	h.sum += d
+}
+
+func (h *histogram) Summary() summary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := summary{
+		Count: h.count,
+		Early: h.early,
+		Min:   h.min.String(),
+		Max:   h.max.String(),
+	}
+	if h.count > 0 {
+		s.Mean = (h.sum / time.Duration(h.count)).String()
+	}
+	var cumul uint64
+	for i, c := range h.counts {
+		cumul += c
+		b := bucket{Upper: "+Inf", Count: cumul}
+		if i < len(latencyBuckets) {
+			b.Upper = latencyBuckets[i].String()
+		}
+		s.Buckets = append(s.Buckets, b)
+	}
+	return s
+}
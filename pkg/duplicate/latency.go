@@ -0,0 +1,211 @@
+package duplicate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A latency probe starts with a byte that can not start a CCSDS packet (whose
+// version is always 0) so that the receiving instance does not mistake a
+// regular packet for a probe.
+const (
+	latencyMagic   = "\xffDLP"
+	latencyVersion = 1
+	latencyHeader  = len(latencyMagic) + 1 + 8 + 8 + 1
+)
+
+func (r Route) checkLatencyProbe() error {
+	if !r.LatencyProbe.isSet() {
+		return nil
+	}
+	if r.Envelope != "" {
+		return fmt.Errorf("latency-probe: not supported with envelope")
+	}
+	return nil
+}
+
+type latencyProber struct {
+	io.WriteCloser
+	name  string
+	every time.Duration
+	clock clock
+
+	mu    sync.Mutex
+	seq   uint64
+	count uint64
+
+	once sync.Once
+	done chan struct{}
+}
+
+func latencyWriter(w io.WriteCloser, name string, every time.Duration, clk clock) *latencyProber {
+	p := latencyProber{
+		WriteCloser: w,
+		name:        name,
+		every:       every,
+		clock:       clk,
+		done:        make(chan struct{}),
+	}
+	if len(p.name) > 255 {
+		p.name = p.name[:255]
+	}
+	go p.run()
+	return &p
+}
+
+func (p *latencyProber) Write(xs []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.WriteCloser.Write(xs)
+}
+
+func (p *latencyProber) run() {
+	tick := time.NewTicker(p.every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			p.send()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *latencyProber) send() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	xs := make([]byte, latencyHeader+len(p.name))
+	n := copy(xs, latencyMagic)
+	xs[n] = latencyVersion
+	binary.BigEndian.PutUint64(xs[n+1:], uint64(p.clock.Now().UnixNano()))
+	binary.BigEndian.PutUint64(xs[n+9:], p.seq)
+	xs[n+17] = byte(len(p.name))
+	copy(xs[latencyHeader:], p.name)
+	p.seq++
+	if _, err := p.WriteCloser.Write(xs); err == nil {
+		atomic.AddUint64(&p.count, 1)
+	}
+}
+
+func (p *latencyProber) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+	})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.WriteCloser.Close()
+}
+
+func (p *latencyProber) sent() uint64 {
+	return atomic.LoadUint64(&p.count)
+}
+
+type latencyStats struct {
+	Route  string  `json:"route"`
+	Source string  `json:"source"`
+	Lost   uint64  `json:"lost"`
+	Jitter string  `json:"jitter"`
+	Delay  summary `json:"delay"`
+}
+
+type latencyState struct {
+	source  string
+	delay   *histogram
+	next    uint64
+	lost    uint64
+	transit time.Duration
+	jitter  time.Duration
+}
+
+// latencyTracker recognizes the probes sent by the routes of other instances
+// and measures the one-way delay and the jitter (as defined by RFC 3550) of
+// each of them.
+type latencyTracker struct {
+	mu     sync.Mutex
+	routes map[string]*latencyState
+}
+
+func latencyProbes() *latencyTracker {
+	return &latencyTracker{
+		routes: make(map[string]*latencyState),
+	}
+}
+
+func (t *latencyTracker) accept(xs []byte, m meta) bool {
+	if len(xs) < latencyHeader || string(xs[:len(latencyMagic)]) != latencyMagic {
+		return false
+	}
+	n := len(latencyMagic)
+	if xs[n] != latencyVersion || len(xs) != latencyHeader+int(xs[n+17]) {
+		return false
+	}
+	var (
+		sent = time.Unix(0, int64(binary.BigEndian.Uint64(xs[n+1:])))
+		seq  = binary.BigEndian.Uint64(xs[n+9:])
+		name = string(xs[latencyHeader:])
+		src  = m.source()
+	)
+	if h, _, err := net.SplitHostPort(src); err == nil {
+		src = h
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := name + "@" + src
+	s, ok := t.routes[key]
+	if !ok {
+		s = &latencyState{
+			source: src,
+			delay:  newHistogram(0),
+		}
+		t.routes[key] = s
+	}
+	transit := m.when.Sub(sent)
+	if ok {
+		if seq > s.next {
+			s.lost += seq - s.next
+		}
+		d := transit - s.transit
+		if d < 0 {
+			d = -d
+		}
+		s.jitter += (d - s.jitter) / 16
+	}
+	if !ok || seq >= s.next {
+		s.next = seq + 1
+	}
+	s.transit = transit
+	s.delay.Observe(transit)
+	return true
+}
+
+func (t *latencyTracker) stats() []latencyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ls []latencyStats
+	for key, s := range t.routes {
+		ls = append(ls, latencyStats{
+			Route:  key[:len(key)-len(s.source)-1],
+			Source: s.source,
+			Lost:   s.lost,
+			Jitter: s.jitter.String(),
+			Delay:  s.delay.Summary(),
+		})
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		if ls[i].Route != ls[j].Route {
+			return ls[i].Route < ls[j].Route
+		}
+		return ls[i].Source < ls[j].Source
+	})
+	return ls
+}
@@ -0,0 +1,136 @@
+package duplicate
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRetryMin = time.Second
+	DefaultRetryMax = 30 * time.Second
+)
+
+// ErrNotConnected is returned for the packets of a route whose connection is
+// being dialed.
+var ErrNotConnected = errors.New("route not connected")
+
+// lazyConn is the connection of a route that is dialed in the background. When
+// a write fails, the connection is closed and dialed again, with a backoff,
+// while the packets of the route are dropped with ErrNotConnected.
+type lazyConn struct {
+	route  Route
+	clock  clock
+	logger *log.Logger
+
+	mu      sync.Mutex
+	conn    io.WriteCloser
+	dialing bool
+	closed  bool
+	done    chan struct{}
+}
+
+func dialLazy(r Route, clk clock, logger *log.Logger) io.WriteCloser {
+	c := lazyConn{
+		route:  r,
+		clock:  clk,
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+	c.redial()
+	return &c
+}
+
+// redialConn gives a connection that is dialed again when writing to w fails.
+func redialConn(r Route, w io.WriteCloser, clk clock, logger *log.Logger) io.WriteCloser {
+	return &lazyConn{
+		route:  r,
+		clock:  clk,
+		logger: logger,
+		conn:   w,
+		done:   make(chan struct{}),
+	}
+}
+
+// redial starts to dial the route in the background. It must be called with
+// the lock held.
+func (c *lazyConn) redial() {
+	if c.dialing || c.closed {
+		return
+	}
+	c.dialing = true
+	go c.connect()
+}
+
+func (c *lazyConn) connect() {
+	var (
+		r    = c.route
+		wait = DefaultRetryMin
+	)
+	for {
+		w, err := dial(r)
+		if err == nil {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.dialing = false
+			if c.closed {
+				w.Close()
+				return
+			}
+			c.conn = w
+			c.logger.Printf("%s: connected to %s", r.Name, r.Addr)
+			return
+		}
+		c.logger.Printf("%s: %s (retrying in %s)", r.Name, describe(err), wait)
+		select {
+		case <-c.clock.After(wait):
+		case <-c.done:
+			return
+		}
+		if wait *= 2; wait > DefaultRetryMax {
+			wait = DefaultRetryMax
+		}
+	}
+}
+
+// Write does not hold the lock while writing, so that Close can unblock a
+// write stuck on the connection.
+func (c *lazyConn) Write(xs []byte) (int, error) {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return 0, ErrNotConnected
+	}
+	n, err := conn.Write(xs)
+	if err != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.conn == conn {
+			c.logger.Printf("%s: %s (reconnecting)", c.route.Name, describe(err))
+			c.conn.Close()
+			c.conn = nil
+			c.redial()
+		}
+	}
+	return n, err
+}
+
+func (c *lazyConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
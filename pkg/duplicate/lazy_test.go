@@ -0,0 +1,45 @@
+package duplicate
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+type stuckConn struct {
+	closed chan struct{}
+}
+
+func (c stuckConn) Write(xs []byte) (int, error) {
+	<-c.closed
+	return 0, io.ErrClosedPipe
+}
+
+func (c stuckConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestRedialCloseStuckWrite(t *testing.T) {
+	w := redialConn(Route{Name: "test"}, stuckConn{closed: make(chan struct{})}, wallClock{}, log.New(io.Discard, "", 0))
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write([]byte("data"))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		w.Close()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("close blocked by a stuck write")
+	}
+	if err := <-done; err == nil {
+		t.Fatalf("write not failed after close")
+	}
+}
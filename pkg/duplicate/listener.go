@@ -0,0 +1,162 @@
+package duplicate
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Listener is an address on which a tenant receives its packets.
+type Listener struct {
+	Remote     string
+	Ifi        string `toml:"nic"`
+	Proto      string `toml:"protocol"`
+	Network    string
+	Sources    []string `toml:"ssm-sources"`
+	Filter     string
+	Decompress string
+	GRO        bool `toml:"gro"`
+	Timestamp  bool
+	Framing    string
+	Detect     bool `toml:"autodetect"`
+	Concurrent bool
+	MaxConns   int `toml:"max-connections"`
+}
+
+func (t Tenant) listeners() []Listener {
+	var ls []Listener
+	if t.Remote != "" {
+		ls = append(ls, Listener{
+			Remote:     t.Remote,
+			Ifi:        t.Ifi,
+			Proto:      t.Proto,
+			Network:    t.Network,
+			Sources:    t.Sources,
+			Filter:     t.Filter,
+			Decompress: t.Decompress,
+			GRO:        t.GRO,
+			Timestamp:  t.Timestamp,
+			Framing:    t.Framing,
+			Detect:     t.Detect,
+			Concurrent: t.Concurrent,
+			MaxConns:   t.MaxConns,
+		})
+	}
+	return append(ls, t.Listeners...)
+}
+
+type packet struct {
+	body *[]byte
+	addr net.Addr
+	when time.Time
+}
+
+type funnel struct {
+	queue chan packet
+	done  chan struct{}
+	once  sync.Once
+	last  time.Time
+}
+
+func makeFunnel(size int) funnel {
+	return funnel{
+		queue: make(chan packet, size),
+		done:  make(chan struct{}),
+	}
+}
+
+func (f *funnel) push(xs []byte, addr net.Addr, when time.Time) bool {
+	p := packet{
+		body: getPacket(len(xs)),
+		addr: addr,
+		when: when,
+	}
+	copy(*p.body, xs)
+	select {
+	case f.queue <- p:
+		return true
+	case <-f.done:
+		putPacket(p.body)
+		return false
+	}
+}
+
+func (f *funnel) ReadFrom(xs []byte) (int, net.Addr, error) {
+	select {
+	case p := <-f.queue:
+		defer putPacket(p.body)
+		f.last = p.when
+		return copy(xs, *p.body), p.addr, nil
+	case <-f.done:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (f *funnel) stamp() time.Time {
+	return f.last
+}
+
+func (f *funnel) stop(fn func()) {
+	f.once.Do(func() {
+		close(f.done)
+		fn()
+	})
+}
+
+type merged struct {
+	funnel
+	rs      []PacketReader
+	arbiter *arbiter
+}
+
+func mergeReaders(rs []PacketReader, arb *arbiter) PacketReader {
+	if len(rs) == 1 && arb == nil {
+		return rs[0]
+	}
+	m := merged{
+		funnel:  makeFunnel(len(rs)),
+		rs:      rs,
+		arbiter: arb,
+	}
+	for i, r := range rs {
+		go m.run(i, r)
+	}
+	return &m
+}
+
+func (m *merged) run(i int, r PacketReader) {
+	xs := make([]byte, MaxPacketSize)
+	s, _ := r.(stamper)
+	for {
+		n, addr, err := r.ReadFrom(xs)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		if m.arbiter != nil && !m.arbiter.accept(i, xs[:n]) {
+			continue
+		}
+		var when time.Time
+		if s != nil {
+			when = s.stamp()
+		}
+		if !m.push(xs[:n], addr, when) {
+			return
+		}
+	}
+}
+
+func (m *merged) Close() error {
+	var err error
+	m.stop(func() {
+		for _, r := range m.rs {
+			if e := r.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,36 @@
+package duplicate
+
+import (
+	"fmt"
+	"io"
+)
+
+func (r Route) checkBufferFile() error {
+	if r.BufferFile != "" && !r.Delay.isSet() && !r.Annotate {
+		return fmt.Errorf("buffer-file: only supported by delayed routes")
+	}
+	return nil
+}
+
+func withMapping(buf []byte) option {
+	return func(r *ring) {
+		if buf == nil {
+			return
+		}
+		r.buffer, r.mapped = buf, true
+	}
+}
+
+func (r *ring) unmap() error {
+	if !r.mapped {
+		return io.EOF
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.buffer != nil {
+		munmap(r.buffer)
+		r.buffer = nil
+	}
+	return io.EOF
+}
@@ -0,0 +1,38 @@
+//go:build linux
+
+package duplicate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func mapFile(file string, size int) ([]byte, error) {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = syscall.Fallocate(int(f.Fd()), 0, 0, int64(size))
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		err = f.Truncate(int64(size))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("buffer-file: %s: %w", file, err)
+	}
+	buf, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("buffer-file: %s: %w", file, err)
+	}
+	return buf, nil
+}
+
+func munmap(buf []byte) error {
+	return syscall.Munmap(buf)
+}
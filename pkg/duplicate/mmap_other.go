@@ -0,0 +1,13 @@
+//go:build !linux
+
+package duplicate
+
+import "fmt"
+
+func mapFile(file string, size int) ([]byte, error) {
+	return nil, fmt.Errorf("buffer-file: %w", ErrUnsupported)
+}
+
+func munmap(buf []byte) error {
+	return nil
+}
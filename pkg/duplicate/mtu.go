@@ -0,0 +1,132 @@
+package duplicate
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	maxDatagram = 65507
+	mtuFlush    = 10 * time.Millisecond
+)
+
+func (r Route) checkMTU() error {
+	if r.MTU == 0 {
+		return nil
+	}
+	if r.MTU < 0 || r.MTU > maxDatagram {
+		return fmt.Errorf("mtu: size should be between 1 and %d", maxDatagram)
+	}
+	if (r.Proto != "" && r.Proto != DefaultProtocol) || r.Listen || r.Share {
+		return fmt.Errorf("mtu: only supported by udp routes")
+	}
+	return nil
+}
+
+// chunked tells if one of the listeners of the tenant gives chunks of a byte
+// stream instead of whole packets.
+func (t Tenant) chunked() bool {
+	for _, l := range t.listeners() {
+		if l.Proto == "tcp" && l.Framing == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// segmenter writes the packets of a route in datagrams of a given size. When the
+// packets are chunks of a byte stream, the stream is cut in datagrams of exactly
+// that size. Otherwise, consecutive packets are gathered in a datagram as long
+// as they fit in it and a packet is never split. A datagram that is not full is
+// sent after a short while.
+type segmenter struct {
+	io.WriteCloser
+	size  int
+	split bool
+	clock clock
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *timer
+	err   error
+}
+
+func segmentWriter(w io.WriteCloser, size int, split bool, clk clock) *segmenter {
+	return &segmenter{
+		WriteCloser: w,
+		size:        size,
+		split:       split,
+		clock:       clk,
+		buf:         make([]byte, 0, size),
+	}
+}
+
+func (s *segmenter) Write(xs []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := len(xs)
+	if s.split {
+		for len(xs) > 0 {
+			n := min(s.size-len(s.buf), len(xs))
+			s.buf = append(s.buf, xs[:n]...)
+			if xs = xs[n:]; len(s.buf) == s.size {
+				s.flush()
+			}
+		}
+	} else {
+		if len(s.buf)+len(xs) > s.size {
+			s.flush()
+		}
+		if len(xs) >= s.size {
+			s.send(xs)
+		} else {
+			s.buf = append(s.buf, xs...)
+		}
+	}
+	if len(s.buf) > 0 {
+		s.schedule()
+	}
+	if err := s.err; err != nil {
+		s.err = nil
+		return 0, err
+	}
+	return size, nil
+}
+
+func (s *segmenter) schedule() {
+	if s.timer == nil {
+		s.timer = s.clock.AfterFunc(mtuFlush, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.flush()
+		})
+		return
+	}
+	s.timer.Reset(mtuFlush)
+}
+
+func (s *segmenter) flush() {
+	if len(s.buf) == 0 {
+		return
+	}
+	s.send(s.buf)
+	s.buf = s.buf[:0]
+}
+
+func (s *segmenter) send(xs []byte) {
+	if _, err := s.WriteCloser.Write(xs); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *segmenter) Close() error {
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.flush()
+	s.mu.Unlock()
+	return s.WriteCloser.Close()
+}
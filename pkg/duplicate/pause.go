@@ -0,0 +1,94 @@
+package duplicate
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+const pausePoll = 100 * time.Millisecond
+
+func checkPause(policy string) (bool, error) {
+	switch policy {
+	case "", outsideDrop:
+		return false, nil
+	case outsideBuffer:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s: invalid on-pause policy (available: drop, buffer)", policy)
+	}
+}
+
+// watchPause applies the pause file each time the process receives SIGUSR2:
+// the routes listed in the file are paused and all the others are resumed.
+func (d *Duplicator) watchPause(ctx context.Context, file string) {
+	sig := make(chan os.Signal, 1)
+	if err := notifyPause(sig); err != nil {
+		log.Printf("pause: %s", err)
+		return
+	}
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			if err := d.applyPause(file); err != nil {
+				log.Printf("pause: %s", err)
+			}
+		}
+	}
+}
+
+func (d *Duplicator) applyPause(file string) error {
+	names, err := readPause(file)
+	if err != nil {
+		return err
+	}
+	for n := range names {
+		var found bool
+		for _, x := range d.relays {
+			if x.Lookup(n) != nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Printf("pause: %s: route not found", n)
+		}
+	}
+	for _, x := range d.relays {
+		for _, rt := range x.routes {
+			if names[x.qualify(rt.Name)] {
+				rt.Pause()
+			} else {
+				rt.Resume()
+			}
+		}
+	}
+	return nil
+}
+
+func readPause(file string) (map[string]bool, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	names := make(map[string]bool)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names[line] = true
+	}
+	return names, s.Err()
+}
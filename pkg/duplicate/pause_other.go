@@ -0,0 +1,12 @@
+//go:build !unix
+
+package duplicate
+
+import (
+	"fmt"
+	"os"
+)
+
+func notifyPause(sig chan<- os.Signal) error {
+	return fmt.Errorf("SIGUSR2: %w", ErrUnsupported)
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package duplicate
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func notifyPause(sig chan<- os.Signal) error {
+	signal.Notify(sig, syscall.SIGUSR2)
+	return nil
+}
@@ -0,0 +1,77 @@
+package duplicate
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// buffers are taken from the smallest class able to hold the packet so that
+// small packets waiting in the queues do not pin a buffer of MaxPacketSize bytes.
+var packetClasses = []int{512, 2048, 9216, MaxPacketSize}
+
+type poolStats struct {
+	Gets      uint64 `json:"gets"`
+	Puts      uint64 `json:"puts"`
+	Allocated uint64 `json:"allocated"`
+	Held      uint64 `json:"held"`
+}
+
+var packets = struct {
+	pools []sync.Pool
+	state poolStats
+}{
+	pools: make([]sync.Pool, len(packetClasses)),
+}
+
+func init() {
+	for i, size := range packetClasses {
+		size := size
+		packets.pools[i].New = func() interface{} {
+			atomic.AddUint64(&packets.state.Allocated, uint64(size))
+			buf := make([]byte, size)
+			return &buf
+		}
+	}
+}
+
+func packetClass(size int) int {
+	for i, c := range packetClasses {
+		if size <= c {
+			return i
+		}
+	}
+	return -1
+}
+
+func getPacket(size int) *[]byte {
+	var buf *[]byte
+	if i := packetClass(size); i < 0 {
+		atomic.AddUint64(&packets.state.Allocated, uint64(size))
+		xs := make([]byte, size)
+		buf = &xs
+	} else {
+		buf = packets.pools[i].Get().(*[]byte)
+		*buf = (*buf)[:size]
+	}
+	atomic.AddUint64(&packets.state.Gets, 1)
+	atomic.AddUint64(&packets.state.Held, uint64(cap(*buf)))
+	return buf
+}
+
+func putPacket(buf *[]byte) {
+	size := cap(*buf)
+	atomic.AddUint64(&packets.state.Puts, 1)
+	atomic.AddUint64(&packets.state.Held, ^uint64(size-1))
+	if i := packetClass(size); i >= 0 && packetClasses[i] == size {
+		packets.pools[i].Put(buf)
+	}
+}
+
+func poolUsage() poolStats {
+	return poolStats{
+		Gets:      atomic.LoadUint64(&packets.state.Gets),
+		Puts:      atomic.LoadUint64(&packets.state.Puts),
+		Allocated: atomic.LoadUint64(&packets.state.Allocated),
+		Held:      atomic.LoadUint64(&packets.state.Held),
+	}
+}
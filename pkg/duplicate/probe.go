@@ -0,0 +1,164 @@
+package duplicate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const DefaultProbeTimeout = time.Second
+
+const (
+	stateUnknown int32 = iota
+	stateUp
+	stateDown
+)
+
+var states = []string{"unknown", "up", "down"}
+
+// Probe configures the health check of a route.
+type Probe struct {
+	Interval Duration
+	Timeout  Duration
+	Payload  string
+	Reply    bool
+}
+
+func (p Probe) isSet() bool {
+	return p.Interval.isSet()
+}
+
+type probeStats struct {
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Failures uint64    `json:"failures"`
+}
+
+type prober struct {
+	name    string
+	addr    string
+	proto   string
+	every   time.Duration
+	timeout time.Duration
+	payload []byte
+	reply   bool
+	clock   clock
+	logger  *log.Logger
+
+	state    int32
+	since    atomic.Value
+	failures uint64
+
+	once sync.Once
+	done chan struct{}
+}
+
+func newProber(r Route, clk clock, logger *log.Logger) (*prober, error) {
+	p := prober{
+		name:    r.Name,
+		addr:    r.Addr,
+		proto:   r.Proto,
+		every:   r.Probe.Interval.In(time.Second),
+		timeout: DefaultProbeTimeout,
+		payload: []byte(r.Probe.Payload),
+		reply:   r.Probe.Reply,
+		clock:   clk,
+		logger:  logger,
+		done:    make(chan struct{}),
+	}
+	switch p.proto {
+	case "":
+		p.proto = DefaultProtocol
+	case DefaultProtocol, "tcp":
+	default:
+		return nil, fmt.Errorf("%s: probe not supported", p.proto)
+	}
+	if r.Probe.Timeout.isSet() {
+		p.timeout = r.Probe.Timeout.In(time.Millisecond)
+	}
+	p.since.Store(p.clock.Now())
+	go p.run()
+	return &p, nil
+}
+
+func (p *prober) run() {
+	tick := p.clock.NewTicker(p.every)
+	defer tick.Stop()
+	for {
+		p.update(p.check())
+		select {
+		case <-tick.C:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *prober) check() error {
+	if p.proto == "tcp" {
+		c, err := net.DialTimeout(p.proto, p.addr, p.timeout)
+		if err == nil {
+			c.Close()
+		}
+		return err
+	}
+	c, err := net.Dial(p.proto, p.addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err := c.Write(p.payload); err != nil {
+		return err
+	}
+	c.SetReadDeadline(time.Now().Add(p.timeout))
+	_, err = c.Read(make([]byte, 1<<16))
+	if errors.Is(err, os.ErrDeadlineExceeded) && !p.reply {
+		return nil
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("port unreachable")
+	}
+	return err
+}
+
+func (p *prober) update(err error) {
+	next := stateUp
+	if err != nil {
+		next = stateDown
+		atomic.AddUint64(&p.failures, 1)
+	}
+	if prev := atomic.SwapInt32(&p.state, next); prev == next {
+		return
+	}
+	p.since.Store(p.clock.Now())
+	if err != nil {
+		p.logger.Printf("%s: remote %s is down: %s", p.name, p.addr, err)
+	} else {
+		p.logger.Printf("%s: remote %s is up", p.name, p.addr)
+	}
+}
+
+func (p *prober) down() bool {
+	return atomic.LoadInt32(&p.state) == stateDown
+}
+
+func (p *prober) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+	})
+	return nil
+}
+
+func (p *prober) stats() *probeStats {
+	return &probeStats{
+		State:    states[atomic.LoadInt32(&p.state)],
+		Since:    p.since.Load().(time.Time),
+		Failures: atomic.LoadUint64(&p.failures),
+	}
+}
@@ -0,0 +1,128 @@
+package duplicate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// The proxy envelope prefixes each packet with the binary header of the version
+// 2 of the PROXY protocol, carrying the address of the original sender. The
+// destination address of the header is left empty.
+const (
+	proxySignature = "\r\n\r\n\x00\r\nQUIT\n"
+	proxyCommand   = 0x21
+	proxyHeaderLen = len(proxySignature) + 4
+
+	proxyUnspec = 0x00
+	proxyInet   = 0x10
+	proxyInet6  = 0x20
+	proxyStream = 0x01
+	proxyDgram  = 0x02
+)
+
+func wrapProxy(m meta, _ uint64, xs []byte) ([]byte, error) {
+	var (
+		ip     net.IP
+		port   int
+		family byte = proxyUnspec
+	)
+	switch a := m.addr.(type) {
+	case *net.UDPAddr:
+		ip, port, family = a.IP, a.Port, proxyDgram
+	case *net.TCPAddr:
+		ip, port, family = a.IP, a.Port, proxyStream
+	}
+	var size int
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, size, family = ip4, 2*net.IPv4len+4, family|proxyInet
+	} else if len(ip) == net.IPv6len {
+		size, family = 2*net.IPv6len+4, family|proxyInet6
+	} else {
+		family = proxyUnspec
+	}
+	buf := make([]byte, 0, proxyHeaderLen+size+len(xs))
+	buf = append(buf, proxySignature...)
+	buf = append(buf, proxyCommand, family)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(size))
+	if size > 0 {
+		buf = append(buf, ip...)
+		buf = append(buf, make([]byte, len(ip))...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(port))
+		buf = binary.BigEndian.AppendUint16(buf, 0)
+	}
+	return append(buf, xs...), nil
+}
+
+// proxyReader removes the header of the proxy envelope from the incoming
+// packets and gives the address of the original sender in place of the address
+// of the sending duplicate instance.
+type proxyReader struct {
+	PacketReader
+	buf []byte
+}
+
+func unwrapProxy(r PacketReader) PacketReader {
+	return &proxyReader{
+		PacketReader: r,
+		buf:          make([]byte, MaxPacketSize),
+	}
+}
+
+func (p *proxyReader) ReadFrom(xs []byte) (int, net.Addr, error) {
+	n, addr, err := p.PacketReader.ReadFrom(p.buf)
+	if err != nil {
+		return n, addr, err
+	}
+	body, src, err := parseProxy(p.buf[:n])
+	if err != nil {
+		return 0, addr, err
+	}
+	if src != nil {
+		addr = src
+	}
+	if len(body) > len(xs) {
+		return 0, addr, io.ErrShortBuffer
+	}
+	return copy(xs, body), addr, nil
+}
+
+func parseProxy(xs []byte) ([]byte, net.Addr, error) {
+	if len(xs) < proxyHeaderLen || !bytes.HasPrefix(xs, []byte(proxySignature)) {
+		return nil, nil, fmt.Errorf("%w: proxy header expected", ErrInvalid)
+	}
+	var (
+		family = xs[len(proxySignature)+1]
+		size   = int(binary.BigEndian.Uint16(xs[len(proxySignature)+2:]))
+	)
+	if xs[len(proxySignature)]>>4 != proxyCommand>>4 {
+		return nil, nil, fmt.Errorf("%w: proxy version not supported", ErrInvalid)
+	}
+	if xs = xs[proxyHeaderLen:]; len(xs) < size {
+		return nil, nil, fmt.Errorf("%w: proxy header truncated", ErrInvalid)
+	}
+	var ip net.IP
+	switch family & 0xF0 {
+	case proxyInet:
+		if size >= 2*net.IPv4len+4 {
+			ip = net.IP(append([]byte(nil), xs[:net.IPv4len]...))
+		}
+	case proxyInet6:
+		if size >= 2*net.IPv6len+4 {
+			ip = net.IP(append([]byte(nil), xs[:net.IPv6len]...))
+		}
+	}
+	var addr net.Addr
+	if ip != nil {
+		port := int(binary.BigEndian.Uint16(xs[2*len(ip):]))
+		switch family & 0x0F {
+		case proxyDgram:
+			addr = &net.UDPAddr{IP: ip, Port: port}
+		case proxyStream:
+			addr = &net.TCPAddr{IP: ip, Port: port}
+		}
+	}
+	return xs[size:], addr, nil
+}
@@ -0,0 +1,128 @@
+package duplicate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const DefaultRouteQueue = 1024
+
+const (
+	overflowNewest = "drop-newest"
+	overflowOldest = "drop-oldest"
+	overflowBlock  = "block"
+)
+
+func checkOverflow(policy string) error {
+	switch policy {
+	case "", overflowNewest, overflowOldest, overflowBlock:
+		return nil
+	default:
+		return fmt.Errorf("%s: unknown overflow policy (available: %s, %s, %s)", policy, overflowNewest, overflowOldest, overflowBlock)
+	}
+}
+
+type queue struct {
+	items    chan *[]byte
+	overflow string
+	dropped  *counter
+
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newQueue(ctx context.Context, size int, overflow string, dropped *counter) (io.ReadCloser, io.WriteCloser) {
+	if size <= 0 {
+		size = DefaultRouteQueue
+	}
+	q := queue{
+		items:    make(chan *[]byte, size),
+		overflow: overflow,
+		dropped:  dropped,
+	}
+	q.ctx, q.cancel = context.WithCancel(ctx)
+	return queueReader{&q}, &q
+}
+
+// queueReader is the reading side of a queue. Closing it stops the queue but
+// leaves its channel open: only the writer closes it, once it is done writing.
+type queueReader struct {
+	*queue
+}
+
+func (r queueReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+func (q *queue) Write(xs []byte) (int, error) {
+	buf := getPacket(len(xs))
+	copy(*buf, xs)
+	switch q.overflow {
+	case overflowBlock:
+		select {
+		case q.items <- buf:
+		case <-q.ctx.Done():
+			putPacket(buf)
+			return 0, io.ErrClosedPipe
+		}
+	case overflowOldest:
+		for {
+			select {
+			case q.items <- buf:
+				return len(xs), nil
+			default:
+			}
+			select {
+			case old := <-q.items:
+				q.dropped.count(len(*old))
+				putPacket(old)
+			default:
+			}
+		}
+	default:
+		select {
+		case q.items <- buf:
+		default:
+			q.dropped.count(len(xs))
+			putPacket(buf)
+		}
+	}
+	return len(xs), nil
+}
+
+func (q *queue) Read(xs []byte) (int, error) {
+	select {
+	case buf, ok := <-q.items:
+		if !ok {
+			return 0, io.EOF
+		}
+		defer putPacket(buf)
+		if len(*buf) > len(xs) {
+			return 0, io.ErrShortBuffer
+		}
+		return copy(xs, *buf), nil
+	case <-q.ctx.Done():
+		return 0, io.EOF
+	}
+}
+
+func (q *queue) Close() error {
+	err := ErrClosed
+	q.once.Do(func() {
+		close(q.items)
+		err = nil
+	})
+	return err
+}
+
+func (q *queue) depth() int {
+	return len(q.items)
+}
+
+func (q *queue) Abort() {
+	q.cancel()
+}
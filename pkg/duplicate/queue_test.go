@@ -0,0 +1,35 @@
+package duplicate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestQueueReaderClose(t *testing.T) {
+	for _, policy := range []string{overflowNewest, overflowOldest, overflowBlock} {
+		rg, wg := newQueue(context.Background(), 1, policy, new(counter))
+		wg.Write([]byte("aaaa"))
+		done := make(chan error, 1)
+		go func() {
+			for {
+				if _, err := wg.Write([]byte("bbbb")); err != nil {
+					done <- err
+					return
+				}
+				if policy != overflowBlock {
+					done <- nil
+					return
+				}
+			}
+		}()
+		rg.Close()
+		if err := <-done; err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			t.Fatalf("%s: unexpected error: %s", policy, err)
+		}
+		if err := wg.Close(); err != nil {
+			t.Fatalf("%s: unexpected error: %s", policy, err)
+		}
+	}
+}
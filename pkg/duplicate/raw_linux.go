@@ -0,0 +1,220 @@
+//go:build linux
+
+package duplicate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	etherHeaderLen = 14
+	etherTypeVLAN  = 0x8100
+	ipProtoUDP     = 17
+)
+
+type rawListener struct {
+	file *os.File
+	conn syscall.RawConn
+	addr *net.UDPAddr
+	buf  []byte
+}
+
+func listenRaw(l Listener) (PacketReader, error) {
+	n, err := network(DefaultProtocol, l.Network)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr(n, l.Remote)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseFilter(l.Filter)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, int(htons(syscall.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("raw socket: %w", err)
+	}
+	if err := setupRaw(fd, l.Ifi, filter); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "raw:"+l.Remote)
+	conn, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r := rawListener{
+		file: file,
+		conn: conn,
+		addr: addr,
+		buf:  make([]byte, 1<<16),
+	}
+	return &r, nil
+}
+
+func (l Listener) checkRaw() error {
+	n, err := network(DefaultProtocol, l.Network)
+	if err != nil {
+		return err
+	}
+	if _, err := net.ResolveUDPAddr(n, l.Remote); err != nil {
+		return err
+	}
+	_, err = parseFilter(l.Filter)
+	return err
+}
+
+func setupRaw(fd int, ifi string, filter []syscall.SockFilter) error {
+	if len(filter) > 0 {
+		if err := syscall.AttachLsf(fd, filter); err != nil {
+			return fmt.Errorf("attach filter: %w", err)
+		}
+	}
+	if ifi == "" {
+		return nil
+	}
+	i, err := net.InterfaceByName(ifi)
+	if err != nil {
+		return err
+	}
+	sa := syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ALL),
+		Ifindex:  i.Index,
+	}
+	if err := syscall.Bind(fd, &sa); err != nil {
+		return err
+	}
+	mreq := make([]byte, 16)
+	binary.NativeEndian.PutUint32(mreq, uint32(i.Index))
+	binary.NativeEndian.PutUint16(mreq[4:], syscall.PACKET_MR_PROMISC)
+	return syscall.SetsockoptString(fd, syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP, string(mreq))
+}
+
+func (r *rawListener) ReadFrom(xs []byte) (int, net.Addr, error) {
+	for {
+		var (
+			n    int
+			from syscall.Sockaddr
+			err  error
+		)
+		rerr := r.conn.Read(func(fd uintptr) bool {
+			n, from, err = syscall.Recvfrom(int(fd), r.buf, 0)
+			return err != syscall.EAGAIN
+		})
+		if rerr != nil {
+			return 0, nil, rerr
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if sa, ok := from.(*syscall.SockaddrLinklayer); ok && sa.Pkttype == syscall.PACKET_OUTGOING {
+			continue
+		}
+		body, src, ok := r.decode(r.buf[:n])
+		if !ok {
+			continue
+		}
+		return copy(xs, body), src, nil
+	}
+}
+
+func (r *rawListener) decode(frame []byte) ([]byte, *net.UDPAddr, bool) {
+	if len(frame) < etherHeaderLen {
+		return nil, nil, false
+	}
+	var (
+		kind = binary.BigEndian.Uint16(frame[12:])
+		body = frame[etherHeaderLen:]
+	)
+	for kind == etherTypeVLAN && len(body) >= 4 {
+		kind, body = binary.BigEndian.Uint16(body[2:]), body[4:]
+	}
+	var (
+		src, dst net.IP
+		udp      []byte
+	)
+	switch kind {
+	case syscall.ETH_P_IP:
+		if len(body) < 20 || body[9] != ipProtoUDP {
+			return nil, nil, false
+		}
+		if frag := binary.BigEndian.Uint16(body[6:]); frag&0x3fff != 0 {
+			return nil, nil, false
+		}
+		size := int(body[0]&0x0f) * 4
+		if len(body) < size {
+			return nil, nil, false
+		}
+		src, dst, udp = net.IP(body[12:16]), net.IP(body[16:20]), body[size:]
+	case syscall.ETH_P_IPV6:
+		if len(body) < 40 || body[6] != ipProtoUDP {
+			return nil, nil, false
+		}
+		src, dst, udp = net.IP(body[8:24]), net.IP(body[24:40]), body[40:]
+	default:
+		return nil, nil, false
+	}
+	if len(udp) < 8 {
+		return nil, nil, false
+	}
+	size := int(binary.BigEndian.Uint16(udp[4:]))
+	if size < 8 || size > len(udp) {
+		return nil, nil, false
+	}
+	port := int(binary.BigEndian.Uint16(udp[2:]))
+	if r.addr.Port != 0 && port != r.addr.Port {
+		return nil, nil, false
+	}
+	if len(r.addr.IP) > 0 && !r.addr.IP.IsUnspecified() && !r.addr.IP.Equal(dst) {
+		return nil, nil, false
+	}
+	from := net.UDPAddr{
+		IP:   append(net.IP(nil), src...),
+		Port: int(binary.BigEndian.Uint16(udp)),
+	}
+	return udp[8:size], &from, true
+}
+
+func (r *rawListener) Close() error {
+	return r.file.Close()
+}
+
+func parseFilter(str string) ([]syscall.SockFilter, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil, nil
+	}
+	var (
+		fs     []syscall.SockFilter
+		fields = strings.FieldsFunc(str, func(r rune) bool {
+			return r == ',' || r == '\n'
+		})
+	)
+	count, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil || count != len(fields)-1 {
+		return nil, fmt.Errorf("filter: invalid number of instructions")
+	}
+	for _, f := range fields[1:] {
+		var x syscall.SockFilter
+		if _, err := fmt.Sscanf(strings.TrimSpace(f), "%d %d %d %d", &x.Code, &x.Jt, &x.Jf, &x.K); err != nil {
+			return nil, fmt.Errorf("filter: %s: %w", f, err)
+		}
+		fs = append(fs, x)
+	}
+	return fs, nil
+}
+
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
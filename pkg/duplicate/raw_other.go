@@ -0,0 +1,13 @@
+//go:build !linux
+
+package duplicate
+
+import "fmt"
+
+func listenRaw(l Listener) (PacketReader, error) {
+	return nil, l.checkRaw()
+}
+
+func (l Listener) checkRaw() error {
+	return fmt.Errorf("raw: %w", ErrUnsupported)
+}
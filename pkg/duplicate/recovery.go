@@ -0,0 +1,200 @@
+package duplicate
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recovery configures the file where the configuration is saved, with the
+// routes paused at the time, to restart in the same state.
+type Recovery struct {
+	File     string
+	Interval Duration
+}
+
+func (r Recovery) run(ctx context.Context, clk clock, c Config, rs []*relay) {
+	if r.File == "" {
+		return
+	}
+	every := time.Minute
+	if r.Interval.isSet() {
+		every = r.Interval.In(time.Second)
+	}
+	tick := clk.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := r.write(clk, c, rs); err != nil {
+			log.Printf("recovery: %s", err)
+		}
+	}
+}
+
+// write writes the configuration as it was loaded, without its variables
+// expanded nor its paths resolved, with the routes paused at the time of the
+// call.
+func (r Recovery) write(clk clock, c Config, rs []*relay) error {
+	var paused [][]bool
+	for _, x := range rs {
+		var ps []bool
+		for _, rt := range x.routes {
+			ps = append(ps, rt.Paused())
+		}
+		paused = append(paused, ps)
+	}
+	if c.Remote == "" && len(c.Listeners) == 0 {
+		paused = append([][]bool{nil}, paused...)
+	}
+	if c.raw != nil {
+		c = *c.raw
+	}
+	if len(paused) > 0 {
+		c.Routes, c.Groups = pauseRoutes(c.Routes, c.Groups, paused[0])
+		paused = paused[1:]
+	}
+	ts := make([]Tenant, len(c.Tenants))
+	for i, t := range c.Tenants {
+		if i < len(paused) {
+			t.Routes, t.Groups = pauseRoutes(t.Routes, t.Groups, paused[i])
+		}
+		ts[i] = t
+	}
+	c.Tenants = ts
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# written by duplicate at %s\n", clk.Now().UTC().Format(time.RFC3339))
+	encodeTable(&buf, "", reflect.ValueOf(c))
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.File), filepath.Base(r.File)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.File)
+}
+
+// pauseRoutes gives a copy of the routes and groups of a tenant with their
+// paused state, given in the order of Tenant.routes.
+func pauseRoutes(rs, gs []Route, paused []bool) ([]Route, []Route) {
+	rs = append([]Route(nil), rs...)
+	gs = append([]Route(nil), gs...)
+	for i, p := range paused {
+		if i < len(rs) {
+			rs[i].Paused = p
+		} else if i -= len(rs); i < len(gs) {
+			gs[i].Paused = p
+		}
+	}
+	return rs, gs
+}
+
+func encodeTable(buf *bytes.Buffer, prefix string, v reflect.Value) {
+	var (
+		typ    = v.Type()
+		tables []int
+	)
+	for i := 0; i < v.NumField(); i++ {
+		f, fv := typ.Field(i), v.Field(i)
+		if f.PkgPath != "" || fv.IsZero() {
+			continue
+		}
+		if isTable(fv) {
+			tables = append(tables, i)
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			fv = fv.Elem()
+		}
+		fmt.Fprintf(buf, "%s = %s\n", keyName(f), encodeValue(fv))
+	}
+	for _, i := range tables {
+		var (
+			fv  = v.Field(i)
+			key = keyName(typ.Field(i))
+		)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if fv.Kind() == reflect.Struct {
+			fmt.Fprintf(buf, "\n[%s]\n", key)
+			encodeTable(buf, key, fv)
+			continue
+		}
+		for j := 0; j < fv.Len(); j++ {
+			fmt.Fprintf(buf, "\n[[%s]]\n", key)
+			encodeTable(buf, key, fv.Index(j))
+		}
+	}
+}
+
+func isTable(v reflect.Value) bool {
+	if _, ok := v.Interface().(encoding.TextMarshaler); ok {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Slice:
+		return v.Type().Elem().Kind() == reflect.Struct
+	default:
+		return false
+	}
+}
+
+func encodeValue(v reflect.Value) string {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		xs, _ := m.MarshalText()
+		return strconv.Quote(string(xs))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Slice:
+		vs := make([]string, v.Len())
+		for i := range vs {
+			vs[i] = encodeValue(v.Index(i))
+		}
+		return "[" + strings.Join(vs, ", ") + "]"
+	default:
+		return strconv.Quote(fmt.Sprint(v.Interface()))
+	}
+}
+
+func keyName(f reflect.StructField) string {
+	if tag := f.Tag.Get("toml"); tag != "" {
+		return tag
+	}
+	return strings.ToLower(f.Name)
+}
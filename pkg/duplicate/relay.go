@@ -0,0 +1,1187 @@
+package duplicate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrClosed is returned when writing to a closed route buffer.
+var ErrClosed = errors.New("ring already closed")
+
+const (
+	DefaultQueueSize  = 1 << 15
+	DefaultBufferSize = 8 << 20
+)
+
+const DefaultProtocol = "udp"
+
+const (
+	DefaultDrainTimeout = 5 * time.Second
+	DefaultCloseTimeout = time.Second
+)
+
+// Route is a destination of the packets received by a tenant.
+type Route struct {
+	Name      string `json:"name"`
+	Addr      string `toml:"address" json:"address"`
+	Proto     string `toml:"protocol" json:"protocol,omitempty"`
+	Framing   string `json:"framing,omitempty"`
+	Transform string `json:"transform,omitempty"`
+	Strip     int    `toml:"strip-prefix" json:"strip-prefix,omitempty"`
+	Prepend   string `json:"prepend,omitempty"`
+	Compress  string `json:"compress,omitempty"`
+	Envelope  string `json:"envelope,omitempty"`
+	Annotate  bool   `json:"annotate,omitempty"`
+	TTL       int    `toml:"ttl" json:"ttl,omitempty"`
+	TOS       int    `toml:"tos" json:"tos,omitempty"`
+
+	Ifi          string   `toml:"nic" json:"nic,omitempty"`
+	Network      string   `json:"network,omitempty"`
+	Trailer      string   `json:"trailer,omitempty"`
+	FEC          int      `toml:"fec" json:"fec,omitempty"`
+	GSO          int      `toml:"gso" json:"gso,omitempty"`
+	MTU          int      `toml:"mtu" json:"mtu,omitempty"`
+	TrailerKey   string   `toml:"trailer-key" json:"-"`
+	LocalAddr    string   `toml:"local-address" json:"local-address,omitempty"`
+	LocalPort    int      `toml:"local-port" json:"local-port,omitempty"`
+	MulticastTTL int      `toml:"multicast-ttl" json:"multicast-ttl,omitempty"`
+	Loopback     *bool    `json:"loopback,omitempty"`
+	Buffer       Size     `json:"buffer,omitempty"`
+	BufferFile   string   `toml:"buffer-file" json:"buffer-file,omitempty"`
+	Delay        Duration `json:"delay,omitempty"`
+	Shift        Duration `json:"shift,omitempty"`
+	Spill        string   `json:"spill,omitempty"`
+	Interval     Duration `json:"interval,omitempty"`
+	Jitter       Duration `json:"jitter,omitempty"`
+	Distrib      string   `toml:"distribution" json:"distribution,omitempty"`
+	Apids        []string `toml:"apid" json:"apid,omitempty"`
+	Active       []string `json:"active,omitempty"`
+	Outside      string   `json:"outside,omitempty"`
+	Strict       bool     `json:"strict,omitempty"`
+	StrictFile   string   `toml:"strict-file" json:"strict-file,omitempty"`
+	Share        bool     `json:"share,omitempty"`
+	Listen       bool     `json:"listen,omitempty"`
+	Backlog      int      `json:"backlog,omitempty"`
+	Workers      int      `json:"workers,omitempty"`
+	Ordered      bool     `json:"ordered,omitempty"`
+	Queue        int      `json:"queue,omitempty"`
+	Overflow     string   `json:"overflow,omitempty"`
+	Lazy         bool     `json:"lazy,omitempty"`
+	KeepAlive    Duration `toml:"keepalive" json:"keepalive,omitempty"`
+	LatencyProbe Duration `toml:"latency-probe" json:"latency-probe,omitempty"`
+	Policy       string   `json:"policy,omitempty"`
+	MinSize      int      `toml:"min-size" json:"min-size,omitempty"`
+	MaxSize      int      `toml:"max-size" json:"max-size,omitempty"`
+	Paused       bool     `json:"-"`
+	OnPause      string   `toml:"on-pause" json:"on-pause,omitempty"`
+
+	Members     []Route     `toml:"route" json:"routes,omitempty"`
+	Simulate    Simulate    `json:"simulate"`
+	Probe       Probe       `json:"probe"`
+	Heartbeat   Heartbeat   `json:"heartbeat"`
+	Tap         Tap         `json:"tap"`
+	Encryption  Encryption  `json:"-"`
+	Certificate Certificate `json:"-"`
+}
+
+// Tenant is a set of listeners and the routes to which their packets are
+// forwarded.
+type Tenant struct {
+	Name       string
+	Remote     string
+	Ifi        string `toml:"nic"`
+	Proto      string `toml:"protocol"`
+	Network    string
+	Sources    []string `toml:"ssm-sources"`
+	Filter     string
+	Decompress string
+	GRO        bool `toml:"gro"`
+	Timestamp  bool
+	Trailer    string
+	TrailerKey string `toml:"trailer-key"`
+	Framing    string
+	Envelope   string
+	Window     int      `toml:"reorder-window"`
+	Hold       Duration `toml:"reorder-timeout"`
+	FEC        bool     `toml:"fec"`
+	Latency    bool     `toml:"latency-probes"`
+	Allow      []string
+	Deny       []string
+	MinSize    int  `toml:"min-size"`
+	MaxSize    int  `toml:"max-size"`
+	Detect     bool `toml:"autodetect"`
+	Concurrent bool
+	Splice     bool
+	MaxConns   int `toml:"max-connections"`
+	Log        string
+	Shared     Size       `toml:"shared-buffer"`
+	MaxBuffer  Size       `toml:"max-buffer"`
+	MaxRoutes  int        `toml:"max-routes"`
+	Listeners  []Listener `toml:"listener"`
+	Routes     []Route    `toml:"route"`
+	Groups     []Route    `toml:"group"`
+
+	Archive     Archive
+	Capture     Capture
+	Tap         Tap
+	Certificate Certificate
+	Sequence    Sequence
+	Decryption  Encryption
+	Arbitration Arbitration
+}
+
+// String gives the name of the tenant, or default.
+func (t Tenant) String() string {
+	if t.Name == "" {
+		return "default"
+	}
+	return t.Name
+}
+
+// Config is the configuration of duplicate. Its top level fields describe the
+// default tenant.
+type Config struct {
+	Include    []string
+	Remote     string
+	Ifi        string `toml:"nic"`
+	Proto      string `toml:"protocol"`
+	Network    string
+	Sources    []string `toml:"ssm-sources"`
+	Filter     string
+	Decompress string
+	GRO        bool `toml:"gro"`
+	Timestamp  bool
+	Trailer    string
+	TrailerKey string `toml:"trailer-key"`
+	Framing    string
+	Envelope   string
+	Window     int      `toml:"reorder-window"`
+	Hold       Duration `toml:"reorder-timeout"`
+	FEC        bool     `toml:"fec"`
+	Latency    bool     `toml:"latency-probes"`
+	Allow      []string
+	Deny       []string
+	MinSize    int  `toml:"min-size"`
+	MaxSize    int  `toml:"max-size"`
+	Detect     bool `toml:"autodetect"`
+	Concurrent bool
+	Splice     bool
+	MaxConns   int  `toml:"max-connections"`
+	Shared     Size `toml:"shared-buffer"`
+	Admin      string
+	PauseFile  string     `toml:"pause-file"`
+	Listeners  []Listener `toml:"listener"`
+	Routes     []Route    `toml:"route"`
+	Groups     []Route    `toml:"group"`
+	Tenants    []Tenant   `toml:"tenant"`
+
+	Archive     Archive
+	Capture     Capture
+	Tap         Tap
+	Certificate Certificate
+	Resources   Resources
+	Sequence    Sequence
+	Decryption  Encryption
+	Arbitration Arbitration
+	Shutdown    Shutdown
+	Recovery    Recovery
+	Storage     Storage
+	Clock       Clock
+
+	// raw is the configuration before the expansion of the variables.
+	raw *Config
+}
+
+// Shutdown configures the time given to the routes to drain and close when
+// duplicate stops.
+type Shutdown struct {
+	Drain Duration
+	Close Duration
+}
+
+func (s Shutdown) timeouts() (time.Duration, time.Duration) {
+	drain, wait := DefaultDrainTimeout, DefaultCloseTimeout
+	if s.Drain.isSet() {
+		drain = s.Drain.In(time.Millisecond)
+	}
+	if s.Close.isSet() {
+		wait = s.Close.In(time.Millisecond)
+	}
+	return drain, wait
+}
+
+// Default gives the tenant described by the top level fields.
+func (c Config) Default() Tenant {
+	return Tenant{
+		Remote:     c.Remote,
+		Ifi:        c.Ifi,
+		Network:    c.Network,
+		Sources:    c.Sources,
+		Filter:     c.Filter,
+		Decompress: c.Decompress,
+		GRO:        c.GRO,
+		Timestamp:  c.Timestamp,
+		Trailer:    c.Trailer,
+		TrailerKey: c.TrailerKey,
+		Proto:      c.Proto,
+		Framing:    c.Framing,
+		Envelope:   c.Envelope,
+		Window:     c.Window,
+		Hold:       c.Hold,
+		FEC:        c.FEC,
+		Latency:    c.Latency,
+		Allow:      c.Allow,
+		Deny:       c.Deny,
+		MinSize:    c.MinSize,
+		MaxSize:    c.MaxSize,
+		Detect:     c.Detect,
+		Concurrent: c.Concurrent,
+		Splice:     c.Splice,
+		MaxConns:   c.MaxConns,
+		Shared:     c.Shared,
+		Listeners:  c.Listeners,
+		Routes:     c.Routes,
+		Groups:     c.Groups,
+
+		Archive:     c.Archive,
+		Capture:     c.Capture,
+		Tap:         c.Tap,
+		Certificate: c.Certificate,
+		Sequence:    c.Sequence,
+		Decryption:  c.Decryption,
+		Arbitration: c.Arbitration,
+	}
+}
+
+func (c Config) tenants() ([]Tenant, error) {
+	var ts []Tenant
+	if c.Remote != "" || len(c.Listeners) > 0 {
+		ts = append(ts, c.Default())
+	}
+	seen := make(map[string]struct{})
+	for _, t := range c.Tenants {
+		if t.Name == "" {
+			return nil, fmt.Errorf("tenant without name")
+		}
+		if _, ok := seen[t.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate tenant", t.Name)
+		}
+		seen[t.Name] = struct{}{}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// output is a writer fed by the relay with the errors counter of its route, if
+// any. A failing output does not prevent the packets to reach the others.
+type output struct {
+	io.Writer
+	errors *uint64
+}
+
+type relay struct {
+	tenant    Tenant
+	name      string
+	logger    *log.Logger
+	clock     clock
+	input     PacketReader
+	stamp     stamper
+	curr      meta
+	routes    []*route
+	ws        []output
+	cs        []io.Closer
+	in        counter
+	rejected  counter
+	discarded counter
+	corrupted counter
+	accept    acceptFunc
+	seq       *tracker
+	reseq     *resequencer
+	fec       *fecStats
+	arbiter   *arbiter
+	archive   *archiver
+	capture   *capturer
+	tap       *tapper
+	probes    *latencyTracker
+	shared    *broadcast
+	splice    *splicer
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	grp      *errgroup.Group
+	once     sync.Once
+	finished chan struct{}
+}
+
+func setupRelay(ctx context.Context, t Tenant, clk clock) (*relay, error) {
+	if err := t.checkBudget(); err != nil {
+		return nil, err
+	}
+	logger, err := t.logger()
+	if err != nil {
+		return nil, err
+	}
+	x := relay{
+		tenant:   t,
+		name:     t.Name,
+		logger:   logger,
+		clock:    clk,
+		finished: make(chan struct{}),
+	}
+	if t.Splice {
+		if err := t.checkSplice(); err != nil {
+			logger.Printf("splice: %s: using userspace copy", err)
+		} else {
+			ctx, x.cancel = context.WithCancel(ctx)
+			x.grp, x.ctx = errgroup.WithContext(ctx)
+			if x.splice, err = x.spliceRoutes(t); err != nil {
+				x.cancel()
+				return nil, err
+			}
+			return &x, nil
+		}
+	}
+	r, err := x.listen(t)
+	if err != nil {
+		return nil, err
+	}
+	ctx, x.cancel = context.WithCancel(ctx)
+	x.grp, x.ctx = errgroup.WithContext(ctx)
+	if t.MinSize > 0 || t.MaxSize > 0 {
+		x.accept = acceptSize(t.MinSize, t.MaxSize)
+	}
+	if t.Latency {
+		x.probes = latencyProbes()
+	}
+	if s, ok := r.(*resequencer); ok {
+		x.reseq = s
+	}
+	x.input = r
+	if x.seq, err = newTracker(t.Sequence, logger); err != nil {
+		r.Close()
+		return nil, err
+	}
+	a := t.Archive
+	if a.Prefix == "" {
+		a.Prefix = t.Name
+	}
+	if x.archive, err = newArchiver(a, clk, logger); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if x.archive != nil {
+		x.ws = append(x.ws, output{Writer: x.archive})
+		x.cs = append(x.cs, x.archive)
+	}
+	c := t.Capture
+	if c.Prefix == "" {
+		c.Prefix = t.Name
+	}
+	if x.capture, err = newCapturer(c, clk, logger); err != nil {
+		x.close()
+		return nil, err
+	}
+	if x.capture != nil {
+		x.cs = append(x.cs, x.capture)
+	}
+	if x.tap, err = newTapper(t.Tap, clk, logger); err != nil {
+		x.close()
+		return nil, err
+	}
+	if x.tap != nil {
+		x.cs = append(x.cs, x.tap)
+	}
+	if t.Shared.isSet() {
+		x.shared = newBroadcast(int(t.Shared.In(1<<20)), clk)
+		x.shared.recv = x.received()
+		x.ws = append(x.ws, output{Writer: x.shared})
+		x.cs = append(x.cs, x.shared)
+	}
+	for _, r := range t.routes() {
+		var (
+			wg io.WriteCloser
+			rg io.ReadCloser
+		)
+		if r.Name == "" {
+			r.Name = r.Addr
+		}
+		rt := route{Route: r, clock: clk}
+		if r.Paused {
+			rt.Pause()
+		}
+		if err := r.check(); err != nil {
+			x.close()
+			return nil, fmt.Errorf("%s: %w", r.Name, err)
+		}
+		rt.hold, _ = checkPause(r.OnPause)
+		rt.schedule, _ = newSchedule(r.Active, r.Outside)
+		rt.chunked = t.chunked()
+		shared := x.shared != nil && r.shareable()
+		if shared {
+			rt.latency = newHistogram(r.Delay.In(time.Millisecond))
+			rg = x.shared.Cursor(x.ctx, r.Delay.In(time.Millisecond), rt.latency, &rt.overflow)
+		} else if r.spilled() {
+			if rg, wg, err = newSpill(x.ctx, r.Spill, r.Shift.In(time.Second), clk, x.received(), &rt.overflow); err != nil {
+				x.close()
+				return nil, fmt.Errorf("%s: %w", r.Name, err)
+			}
+		} else if r.Delay.isSet() || r.Annotate {
+			if r.Delay.isSet() {
+				rt.latency = newHistogram(r.Delay.In(time.Millisecond))
+			}
+			var held *embargo
+			if r.StrictFile != "" {
+				if held, err = loadEmbargo(r.StrictFile, clk, logger); err != nil {
+					x.close()
+					return nil, fmt.Errorf("%s: %w", r.Name, err)
+				}
+			}
+			var mapped []byte
+			if r.BufferFile != "" {
+				if mapped, err = mapFile(r.BufferFile, int(r.Buffer.In(1))); err != nil {
+					x.close()
+					return nil, fmt.Errorf("%s: %w", r.Name, err)
+				}
+			}
+			rg, wg = newRing(x.ctx, int(r.Buffer.In(1)),
+				withMapping(mapped),
+				withDelay(r.Delay.In(time.Millisecond)),
+				withJitter(r.Jitter.In(time.Millisecond), r.Distrib),
+				withLatency(rt.latency),
+				withStrict(r.Strict),
+				withEmbargo(held),
+				withInterval(r.Interval.In(time.Millisecond)),
+				withAnnotate(r.Annotate, &x.curr),
+				withReceived(x.received()),
+				withClock(clk),
+				withQueue(r.Queue),
+				withOverflow(r.Overflow, &rt.overflow),
+			)
+			if r.Annotate {
+				rt.curr = &rg.(*ring).curr
+			}
+		} else {
+			rg, wg = newQueue(x.ctx, r.Queue, r.Overflow, &rt.overflow)
+		}
+		if d, ok := rg.(interface{ depth() int }); ok {
+			rt.depth = d.depth
+		}
+		if a, ok := rg.(interface{ Abort() }); ok {
+			rt.abort = a.Abort
+		} else {
+			rt.abort = func() { rg.Close() }
+		}
+		if shared {
+			fn, err := duplicateRoute(x.ctx, &rt, rg, logger)
+			if err != nil {
+				x.close()
+				return nil, fmt.Errorf("%s: %w", r.Name, err)
+			}
+			x.grp.Go(fn)
+			x.routes = append(x.routes, &rt)
+			if err := x.probeRoute(&rt); err != nil {
+				x.close()
+				return nil, fmt.Errorf("%s: %w", r.Name, err)
+			}
+			continue
+		}
+		if !r.Annotate {
+			if wg, err = envelopeWriter(wg, r.Envelope, &x.curr); err != nil {
+				x.close()
+				return nil, err
+			}
+		}
+		if wg, err = rewriteWriter(wg, r.Strip, r.Prepend, &rt.filtered); err != nil {
+			x.close()
+			return nil, fmt.Errorf("%s: %w", r.Name, err)
+		}
+		var accepts []acceptFunc
+		if len(r.Apids) > 0 {
+			rs, err := parseApids(r.Apids)
+			if err != nil {
+				x.close()
+				return nil, err
+			}
+			accepts = append(accepts, acceptApids(rs))
+		}
+		if r.MinSize > 0 || r.MaxSize > 0 {
+			accepts = append(accepts, acceptSize(r.MinSize, r.MaxSize))
+		}
+		if len(accepts) > 0 {
+			wg = &filter{
+				WriteCloser: wg,
+				accept:      acceptAll(accepts),
+				skipped:     &rt.filtered,
+			}
+		}
+		fn, err := duplicateRoute(x.ctx, &rt, rg, logger)
+		if err != nil {
+			x.close()
+			return nil, fmt.Errorf("%s: %w", r.Name, err)
+		}
+		x.grp.Go(fn)
+		x.routes = append(x.routes, &rt)
+		x.ws = append(x.ws, output{Writer: wg, errors: &rt.errors})
+		x.cs = append(x.cs, wg)
+		if err := x.probeRoute(&rt); err != nil {
+			x.close()
+			return nil, fmt.Errorf("%s: %w", r.Name, err)
+		}
+	}
+	return &x, nil
+}
+
+// probeRoute starts to probe the remote of the route when configured.
+func (x *relay) probeRoute(rt *route) error {
+	if !rt.Probe.isSet() {
+		return nil
+	}
+	p, err := newProber(rt.Route, x.clock, x.logger)
+	if err != nil {
+		return err
+	}
+	rt.probe = p
+	x.cs = append(x.cs, p)
+	return nil
+}
+
+func (t Tenant) checkBudget() error {
+	if n := len(t.routes()); t.MaxRoutes > 0 && n > t.MaxRoutes {
+		return fmt.Errorf("too many routes (%d > %d)", n, t.MaxRoutes)
+	}
+	if !t.MaxBuffer.isSet() {
+		return nil
+	}
+	var total int64
+	if t.Shared.isSet() {
+		total += t.Shared.In(1 << 20)
+	}
+	for _, r := range t.routes() {
+		if !r.Delay.isSet() || r.BufferFile != "" || (t.Shared.isSet() && r.shareable()) {
+			continue
+		}
+		if !r.Buffer.isSet() {
+			total += DefaultBufferSize
+		} else {
+			total += r.Buffer.In(1)
+		}
+	}
+	if limit := t.MaxBuffer.In(1 << 20); total > limit {
+		return fmt.Errorf("buffers too large (%dMB > %dMB)", total>>20, limit>>20)
+	}
+	return nil
+}
+
+func (t Tenant) logger() (*log.Logger, error) {
+	var prefix string
+	if t.Name != "" {
+		prefix = fmt.Sprintf("[%s] ", t.Name)
+	}
+	if t.Log == "" {
+		return log.New(os.Stderr, prefix, log.LstdFlags), nil
+	}
+	f, err := os.OpenFile(t.Log, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return log.New(f, prefix, log.LstdFlags), nil
+}
+
+// received gives the metadata of the packet being forwarded to the buffers of
+// the routes when its time of reception is given by the kernel.
+func (r *relay) received() *meta {
+	if r.stamp == nil {
+		return nil
+	}
+	return &r.curr
+}
+
+func (r *relay) Lookup(name string) *route {
+	for _, rt := range r.routes {
+		if r.qualify(rt.Name) == name {
+			return rt
+		}
+	}
+	return nil
+}
+
+func (r *relay) qualify(name string) string {
+	if r.name == "" {
+		return name
+	}
+	return r.name + "/" + name
+}
+
+func (r *relay) Run() error {
+	go func() {
+		<-r.ctx.Done()
+		r.Stop()
+	}()
+	if r.splice != nil {
+		r.grp.Go(r.splice.run)
+	} else {
+		r.grp.Go(r.copy)
+	}
+	err := r.grp.Wait()
+	r.cancel()
+	close(r.finished)
+	return err
+}
+
+func (r *relay) copy() error {
+	defer r.close()
+	buf := make([]byte, MaxPacketSize)
+	for {
+		n, addr, err := r.input.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if errors.Is(err, ErrCorrupted) {
+			r.corrupted.count(n)
+		}
+		if err != nil {
+			continue
+		}
+		r.curr = meta{addr: addr, when: received(r.stamp, r.clock)}
+		if r.probes != nil && r.probes.accept(buf[:n], r.curr) {
+			continue
+		}
+		r.in.count(n)
+		if r.accept != nil && !r.accept(buf[:n]) {
+			r.discarded.count(n)
+			continue
+		}
+		if r.capture != nil {
+			r.capture.Record(buf[:n], r.curr)
+		}
+		if r.tap != nil {
+			r.tap.Record(buf[:n], r.curr)
+		}
+		if r.seq != nil && !r.seq.Check(buf[:n]) && r.capture != nil {
+			r.capture.Trigger("sequence")
+		}
+		for _, w := range r.ws {
+			if _, err := w.Write(buf[:n]); err != nil && w.errors != nil {
+				atomic.AddUint64(w.errors, 1)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *relay) Shutdown(drain, wait time.Duration) {
+	r.Stop()
+	select {
+	case <-r.finished:
+		return
+	case <-time.After(drain):
+		r.logger.Printf("routes not drained after %s: discarding buffered packets", drain)
+	}
+	r.cancel()
+	select {
+	case <-r.finished:
+	case <-time.After(wait):
+		r.logger.Printf("routes not closed after %s", wait)
+	}
+}
+
+func (r *relay) Stop() {
+	r.once.Do(func() {
+		if r.splice != nil {
+			r.splice.Close()
+			return
+		}
+		r.input.Close()
+	})
+}
+
+func (r *relay) close() {
+	r.Stop()
+	for _, c := range r.cs {
+		c.Close()
+	}
+}
+
+type counter struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+func (c *counter) count(n int) {
+	atomic.AddUint64(&c.Packets, 1)
+	atomic.AddUint64(&c.Bytes, uint64(n))
+}
+
+func (c *counter) load() counter {
+	return counter{
+		Packets: atomic.LoadUint64(&c.Packets),
+		Bytes:   atomic.LoadUint64(&c.Bytes),
+	}
+}
+
+type route struct {
+	Route
+
+	sent     counter
+	dropped  counter
+	overflow counter
+	filtered counter
+	errors   uint64
+	latency  *histogram
+	probe    *prober
+	beat     *heartbeat
+	timing   *latencyProber
+	chunked  bool
+	curr     *meta
+	schedule *schedule
+	paused   int32
+	hold     bool
+	clock    clock
+
+	abort func()
+	depth func() int
+	conn  io.Closer
+}
+
+func (r *route) queue() int {
+	if r.depth == nil {
+		return 0
+	}
+	return r.depth()
+}
+
+func (r *route) Abort() {
+	r.abort()
+	if r.conn != nil {
+		r.conn.Close()
+	}
+}
+
+func (r *route) Pause() {
+	atomic.StoreInt32(&r.paused, 1)
+}
+
+func (r *route) Resume() {
+	atomic.StoreInt32(&r.paused, 0)
+}
+
+func (r *route) Paused() bool {
+	return atomic.LoadInt32(&r.paused) == 1
+}
+
+func (r *route) wait(ctx context.Context) bool {
+	for r.Paused() {
+		if !r.hold {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-r.clock.After(pausePoll):
+		}
+	}
+	if r.schedule == nil {
+		return true
+	}
+	for !r.schedule.active(r.clock.Now()) {
+		if !r.schedule.buffer {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-r.clock.After(time.Second):
+		}
+	}
+	return true
+}
+
+func duplicateRoute(ctx context.Context, rt *route, r io.ReadCloser, logger *log.Logger) (func() error, error) {
+	if rt.Workers > 1 {
+		return duplicateWorkers(ctx, rt, r, logger)
+	}
+	var (
+		w   io.WriteCloser
+		err error
+	)
+	if len(rt.Members) > 0 {
+		w, err = dialGroup(rt, logger)
+		rt.conn = w
+	} else {
+		w, rt.conn, err = open(rt.Route, rt.clock, logger)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if w, err = routeWriter(rt, w, logger); err != nil {
+		return nil, err
+	}
+	fn := func() error {
+		defer func() {
+			r.Close()
+			w.Close()
+		}()
+		go func() {
+			<-ctx.Done()
+			rt.Abort()
+		}()
+		buf := make([]byte, MaxPacketSize)
+		for {
+			n, err := r.Read(buf)
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+				break
+			}
+			if err != nil {
+				continue
+			}
+			if !rt.wait(ctx) {
+				rt.dropped.count(n)
+				continue
+			}
+			if _, err := w.Write(buf[:n]); errors.Is(err, ErrNotConnected) {
+				rt.dropped.count(n)
+				continue
+			} else if err != nil {
+				atomic.AddUint64(&rt.errors, 1)
+				continue
+			}
+			rt.sent.count(n)
+		}
+		return nil
+	}
+	return fn, nil
+}
+
+// routeWriter wraps the connection of a route with the writers of its options.
+// w is closed on error.
+func routeWriter(rt *route, w io.WriteCloser, logger *log.Logger) (io.WriteCloser, error) {
+	var err error
+	if rt.MTU > 0 {
+		w = segmentWriter(w, rt.MTU, rt.chunked, rt.clock)
+	}
+	if rt.Heartbeat.isSet() {
+		if rt.beat, err = heartbeatWriter(w, rt.Heartbeat, rt.clock); err != nil {
+			w.Close()
+			return nil, err
+		}
+		w = rt.beat
+	}
+	if rt.LatencyProbe.isSet() {
+		rt.timing = latencyWriter(w, rt.Name, rt.LatencyProbe.In(time.Second), rt.clock)
+		w = rt.timing
+	}
+	if rt.Simulate.isSet() {
+		w = simulateWriter(w, rt.Simulate, rt.clock, logger)
+	}
+	if rt.curr != nil {
+		e, err := envelopeWriter(w, rt.Envelope, rt.curr)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w = e
+	}
+	if rt.Tap.isSet() {
+		t, err := newTapper(rt.Tap, rt.clock, logger)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w = tapWriter{WriteCloser: w, tap: t}
+	}
+	return w, nil
+}
+
+func open(r Route, clk clock, logger *log.Logger) (io.WriteCloser, io.Closer, error) {
+	var (
+		w   io.WriteCloser
+		err error
+	)
+	if r.Lazy {
+		w = dialLazy(r, clk, logger)
+	} else if w, err = dialRetry(r); err != nil {
+		return nil, nil, err
+	} else if r.stream() {
+		w = redialConn(r, w, clk, logger)
+	}
+	c := w
+	switch r.Framing {
+	case "":
+	case "length":
+		w = lengthWriter{WriteCloser: w}
+	default:
+		c.Close()
+		return nil, nil, fmt.Errorf("%s: unsupported framing", r.Framing)
+	}
+	if w, err = fecWriter(w, r.FEC, clk); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	if w, err = encryptWriter(w, r.Encryption); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	if w, err = trailerWriter(w, r.Trailer, r.TrailerKey); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	if w, err = transformWriter(w, r.Transform, clk); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	return w, c, nil
+}
+
+// stream tells if the route sends its packets over a connection that has to be
+// dialed again once broken.
+func (r Route) stream() bool {
+	if r.Listen || r.Share {
+		return false
+	}
+	switch r.Proto {
+	case "tcp", "ws", "wss":
+		return true
+	default:
+		return false
+	}
+}
+
+func dial(r Route) (io.WriteCloser, error) {
+	if r.Listen {
+		return listenRoute(r)
+	}
+	if r.Share {
+		return dialShared(r)
+	}
+	switch proto := r.Proto; proto {
+	case "", DefaultProtocol, "tcp":
+		if proto == "" {
+			proto = DefaultProtocol
+		}
+		c, err := dialSocket(proto, r)
+		if err != nil {
+			return nil, err
+		}
+		if u, ok := c.(*net.UDPConn); ok && r.GSO > 0 {
+			return gsoWriter(u, r.GSO)
+		}
+		if r.Compress == "" {
+			return c, nil
+		}
+		return compressWriter(c, r.Compress)
+	default:
+		fn, ok := sinks[proto]
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported protocol (available: udp, tcp, %s)", proto, strings.Join(Sinks(), ", "))
+		}
+		return fn(r)
+	}
+}
+
+func dialSocket(proto string, r Route) (net.Conn, error) {
+	proto, err := network(proto, r.Network)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	if r.LocalAddr != "" || r.LocalPort > 0 {
+		local, err := localAddr(proto, r)
+		if err != nil {
+			return nil, err
+		}
+		d.LocalAddr = local
+		d.Control = func(_, _ string, raw syscall.RawConn) error {
+			var err error
+			raw.Control(func(fd uintptr) {
+				err = reuseAddr(int(fd))
+			})
+			return err
+		}
+	}
+	c, err := d.Dial(proto, r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := routeSockopts(r, c.RemoteAddr())
+	if err == nil {
+		err = setSockopts(c, opts)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if c, ok := c.(*net.TCPConn); ok {
+		if r.KeepAlive.isSet() {
+			c.SetKeepAlive(true)
+			c.SetKeepAlivePeriod(r.KeepAlive.In(time.Second))
+		}
+		go watch(c)
+	}
+	return c, nil
+}
+
+func localAddr(proto string, r Route) (net.Addr, error) {
+	addr := net.JoinHostPort(r.LocalAddr, strconv.Itoa(r.LocalPort))
+	if strings.HasPrefix(proto, "tcp") {
+		return net.ResolveTCPAddr(proto, addr)
+	}
+	return net.ResolveUDPAddr(proto, addr)
+}
+
+func watch(c net.Conn) {
+	io.Copy(io.Discard, c)
+	c.Close()
+}
+
+// PacketReader gives the packets received by a listener with the address of
+// their sender.
+type PacketReader interface {
+	ReadFrom([]byte) (int, net.Addr, error)
+	io.Closer
+}
+
+func (x *relay) listen(c Tenant) (PacketReader, error) {
+	ls := c.listeners()
+	if len(ls) == 0 {
+		return nil, fmt.Errorf("no listener configured")
+	}
+	// the kernel stamps the packets with the time of the wall clock.
+	if _, ok := x.clock.(wallClock); !ok {
+		for _, l := range ls {
+			if l.Timestamp {
+				return nil, fmt.Errorf("%s: timestamp: not supported with the clock table", l.Remote)
+			}
+		}
+	}
+	arb, err := newArbiter(c.Arbitration, ls)
+	if err != nil {
+		return nil, err
+	}
+	if c.FEC {
+		x.fec = new(fecStats)
+	}
+	x.arbiter = arb
+	var rs []PacketReader
+	for _, l := range ls {
+		r, err := l.Listen(c.Certificate)
+		if err != nil {
+			for _, r := range rs {
+				r.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", l.Remote, err)
+		}
+		rs = append(rs, r)
+	}
+	r := mergeReaders(rs, arb)
+	if s, ok := r.(stamper); ok {
+		x.stamp = s
+	}
+	// the acl checks the address of the transport, before any envelope can
+	// replace it with the address claimed by the sender.
+	a, err := aclReader(r, c.Allow, c.Deny, &x.rejected)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	d, err := decryptReader(fecReader(a, x.fec), c.Decryption)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if d, err = trailerReader(d, c.Trailer, c.TrailerKey); err != nil {
+		r.Close()
+		return nil, err
+	}
+	u, err := unwrapReader(d, c.Envelope, c.Window, c.Hold.In(time.Millisecond), x.clock)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	// the resequencer reads in the background and stamps each packet itself.
+	if s, ok := u.(*resequencer); ok {
+		s.stamper, x.stamp = x.stamp, s
+	}
+	return u, nil
+}
+
+// Listen opens the listener, using the certificate for tls.
+func (l Listener) Listen(cert Certificate) (PacketReader, error) {
+	if err := l.checkTimestamp(); err != nil {
+		return nil, err
+	}
+	switch l.Proto {
+	case "", DefaultProtocol:
+		n, err := network(DefaultProtocol, l.Network)
+		if err != nil {
+			return nil, err
+		}
+		c, err := listenUDP(n, l.Remote, l.Ifi, l.Sources...)
+		if err != nil {
+			return nil, err
+		}
+		if l.GRO {
+			return receiveGRO(c)
+		}
+		if l.Timestamp {
+			return receiveTimestamps(c)
+		}
+		return c, nil
+	case "tcp":
+		return listenTCP(l, cert)
+	case "raw":
+		return listenRaw(l)
+	default:
+		return nil, fmt.Errorf("%s: unsupported protocol", l.Proto)
+	}
+}
+
+func listenUDP(n, a, ifi string, sources ...string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr(n, a)
+	if err != nil {
+		return nil, err
+	}
+	if c := inheritUDP(addr); c != nil {
+		return c, nil
+	}
+	if ifi == "" {
+		ifi = addr.Zone
+	}
+	var c *net.UDPConn
+	if addr.IP.IsMulticast() {
+		var i *net.Interface
+		if ifi, err := net.InterfaceByName(ifi); err == nil {
+			i = ifi
+		}
+		if len(sources) > 0 {
+			return listenSSM(n, addr, i, sources)
+		}
+		c, err = net.ListenMulticastUDP(n, i, addr)
+	} else {
+		c, err = net.ListenUDP(n, addr)
+	}
+	return c, err
+}
+
+func network(proto, n string) (string, error) {
+	switch n {
+	case "", proto:
+		return proto, nil
+	case proto + "4", proto + "6":
+		return n, nil
+	default:
+		return "", fmt.Errorf("%s: invalid network for %s (available: %s, %s4, %s6)", n, proto, proto, proto, proto)
+	}
+}
@@ -0,0 +1,53 @@
+package duplicate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Report prints, at the given interval and until ctx is cancelled, the rates
+// and counters of each route.
+func (d *Duplicator) Report(ctx context.Context, w io.Writer, every time.Duration) {
+	if every <= 0 {
+		return
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+
+	last := make(map[*route]counter)
+	for {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		for _, x := range d.relays {
+			for _, rt := range x.routes {
+				var (
+					curr = rt.sent.load()
+					prev = last[rt]
+					secs = every.Seconds()
+				)
+				last[rt] = curr
+				name := rt.Name
+				if x.name != "" {
+					name = x.name + "/" + name
+				}
+				fmt.Fprintf(w, "%s %s: %.1f pkt/s, %.1f KB/s, dropped %d, overflow %d, errors %d, queue %d\n",
+					now,
+					name,
+					float64(curr.Packets-prev.Packets)/secs,
+					float64(curr.Bytes-prev.Bytes)/secs/1024,
+					rt.dropped.load().Packets,
+					rt.overflow.load().Packets,
+					atomic.LoadUint64(&rt.errors),
+					rt.queue(),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package duplicate
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	seqHeaderLen          = 16
+	DefaultReorderWindow  = 64
+	DefaultReorderTimeout = time.Second
+)
+
+func wrapSeq(m meta, seq uint64, xs []byte) ([]byte, error) {
+	buf := make([]byte, seqHeaderLen, seqHeaderLen+len(xs))
+	binary.BigEndian.PutUint64(buf, seq)
+	binary.BigEndian.PutUint64(buf[8:], uint64(m.when.UnixNano()))
+	return append(buf, xs...), nil
+}
+
+type resequenceStats struct {
+	Delivered  uint64 `json:"delivered"`
+	Lost       uint64 `json:"lost"`
+	Duplicates uint64 `json:"duplicates"`
+	Reordered  uint64 `json:"reordered"`
+	Restarts   uint64 `json:"restarts"`
+	Transit    int64  `json:"transit"`
+}
+
+type pending struct {
+	body []byte
+	addr net.Addr
+	when time.Time
+}
+
+type incoming struct {
+	pending
+	size int
+	err  error
+}
+
+// resequencer puts the packets back in the order of their sequence numbers. The
+// packets are read in the background so that the packets held after a gap are
+// released once the hold timeout has elapsed, even if no packet arrives.
+type resequencer struct {
+	PacketReader
+	window  int
+	hold    time.Duration
+	clock   clock
+	stamper stamper
+
+	once  sync.Once
+	stop  sync.Once
+	queue chan incoming
+	done  chan struct{}
+	last  time.Time
+
+	started bool
+	next    uint64
+	pending map[uint64]pending
+	held    time.Time
+
+	mu    sync.Mutex
+	state resequenceStats
+}
+
+func resequenceReader(r PacketReader, window int, hold time.Duration, clk clock) (*resequencer, error) {
+	if window < 0 {
+		return nil, fmt.Errorf("reorder-window: invalid number of packets (%d)", window)
+	}
+	if window == 0 {
+		window = DefaultReorderWindow
+	}
+	if hold < 0 {
+		return nil, fmt.Errorf("reorder-timeout: invalid duration (%s)", hold)
+	}
+	if hold == 0 {
+		hold = DefaultReorderTimeout
+	}
+	s := resequencer{
+		PacketReader: r,
+		window:       window,
+		hold:         hold,
+		clock:        clk,
+		queue:        make(chan incoming),
+		done:         make(chan struct{}),
+		pending:      make(map[uint64]pending),
+	}
+	return &s, nil
+}
+
+func (s *resequencer) run() {
+	defer close(s.queue)
+	buf := make([]byte, MaxPacketSize)
+	for {
+		n, addr, err := s.PacketReader.ReadFrom(buf)
+		in := incoming{
+			pending: pending{
+				body: append([]byte(nil), buf[:n]...),
+				addr: addr,
+				when: received(s.stamper, s.clock),
+			},
+			size: n,
+			err:  err,
+		}
+		select {
+		case s.queue <- in:
+		case <-s.done:
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+func (s *resequencer) ReadFrom(xs []byte) (int, net.Addr, error) {
+	s.once.Do(func() {
+		go s.run()
+	})
+	for {
+		if p, ok := s.pending[s.next]; ok {
+			delete(s.pending, s.next)
+			s.next++
+			s.update(func(st *resequenceStats) { st.Delivered++ })
+			return s.deliver(xs, p)
+		}
+		in, ok, expired := s.wait()
+		if expired {
+			s.skip()
+			continue
+		}
+		if !ok {
+			return 0, nil, net.ErrClosed
+		}
+		if in.err != nil {
+			return in.size, in.addr, in.err
+		}
+		if in.size < seqHeaderLen {
+			return 0, in.addr, fmt.Errorf("%w: sequence header too short", ErrInvalid)
+		}
+		var (
+			seq  = binary.BigEndian.Uint64(in.body)
+			when = time.Unix(0, int64(binary.BigEndian.Uint64(in.body[8:])))
+		)
+		in.body = in.body[seqHeaderLen:]
+		s.update(func(st *resequenceStats) { st.Transit = s.clock.Now().Sub(when).Microseconds() })
+		switch {
+		case !s.started || (seq < s.next && s.next-seq > uint64(s.window)):
+			if s.started {
+				s.update(func(st *resequenceStats) { st.Restarts++ })
+			}
+			s.started, s.next = true, seq+1
+			clear(s.pending)
+		case seq < s.next:
+			s.update(func(st *resequenceStats) { st.Duplicates++ })
+			continue
+		case seq > s.next:
+			if _, ok := s.pending[seq]; ok {
+				s.update(func(st *resequenceStats) { st.Duplicates++ })
+				continue
+			}
+			if len(s.pending) == 0 {
+				s.held = s.clock.Now()
+			}
+			s.pending[seq] = in.pending
+			if seq-s.next >= uint64(s.window) {
+				s.skip()
+			}
+			continue
+		default:
+			s.next++
+			if len(s.pending) > 0 {
+				s.update(func(st *resequenceStats) { st.Reordered++ })
+			}
+		}
+		s.update(func(st *resequenceStats) { st.Delivered++ })
+		return s.deliver(xs, in.pending)
+	}
+}
+
+// wait gives the next packet read, or tells that the packets are held for too
+// long.
+func (s *resequencer) wait() (incoming, bool, bool) {
+	if len(s.pending) == 0 {
+		in, ok := <-s.queue
+		return in, ok, false
+	}
+	t := s.clock.NewTimer(s.clock.Until(s.held.Add(s.hold)))
+	defer t.Stop()
+	select {
+	case in, ok := <-s.queue:
+		return in, ok, false
+	case <-t.C:
+		return incoming{}, true, true
+	}
+}
+
+func (s *resequencer) skip() {
+	first := s.next
+	for seq := range s.pending {
+		if first == s.next || seq < first {
+			first = seq
+		}
+	}
+	lost := first - s.next
+	s.next, s.held = first, s.clock.Now()
+	s.update(func(st *resequenceStats) { st.Lost += lost })
+}
+
+func (s *resequencer) deliver(xs []byte, p pending) (int, net.Addr, error) {
+	if len(p.body) > len(xs) {
+		return 0, p.addr, io.ErrShortBuffer
+	}
+	s.last = p.when
+	return copy(xs, p.body), p.addr, nil
+}
+
+// stamp gives the time at which the last packet delivered was received.
+func (s *resequencer) stamp() time.Time {
+	return s.last
+}
+
+func (s *resequencer) Close() error {
+	s.stop.Do(func() {
+		close(s.done)
+	})
+	return s.PacketReader.Close()
+}
+
+func (s *resequencer) update(fn func(*resequenceStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.state)
+}
+
+func (s *resequencer) stats() *resequenceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.state
+	return &st
+}
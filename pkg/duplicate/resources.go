@@ -0,0 +1,111 @@
+package duplicate
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Resources configures the sampling of the resources used by the process and
+// their limits.
+type Resources struct {
+	Interval      Duration
+	MaxCPU        float64 `toml:"max-cpu"`
+	MaxRSS        Size    `toml:"max-rss"`
+	MaxGoroutines int     `toml:"max-goroutines"`
+	MaxFiles      int     `toml:"max-fds"`
+}
+
+type usage struct {
+	CPU        float64 `json:"cpu"`
+	RSS        uint64  `json:"rss"`
+	Goroutines int     `json:"goroutines"`
+	Files      int     `json:"fds"`
+}
+
+type monitor struct {
+	Resources
+
+	mu   sync.Mutex
+	cpu  time.Duration
+	when time.Time
+}
+
+func newMonitor(r Resources) *monitor {
+	m := monitor{Resources: r}
+	m.cpu, m.when = cputime(), time.Now()
+	return &m
+}
+
+func (m *monitor) Run(ctx context.Context) {
+	if !m.Interval.isSet() {
+		return
+	}
+	tick := time.NewTicker(m.Interval.In(time.Second))
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+		u := m.Sample()
+		log.Printf("cpu: %.1f%%, rss: %dMB, goroutines: %d, fds: %d", u.CPU, u.RSS>>20, u.Goroutines, u.Files)
+		m.check(u)
+	}
+}
+
+func (m *monitor) Sample() usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		now = time.Now()
+		cpu = cputime()
+		u   usage
+	)
+	if elapsed := now.Sub(m.when); elapsed > 0 {
+		u.CPU = float64(cpu-m.cpu) / float64(elapsed) * 100
+	}
+	m.cpu, m.when = cpu, now
+
+	u.RSS = rss()
+	u.Goroutines = runtime.NumGoroutine()
+	if es, err := os.ReadDir("/proc/self/fd"); err == nil {
+		u.Files = len(es) - 1
+	}
+	return u
+}
+
+func (m *monitor) check(u usage) {
+	if m.MaxCPU > 0 && u.CPU > m.MaxCPU {
+		log.Printf("warning: cpu usage %.1f%% above limit (%.1f%%)", u.CPU, m.MaxCPU)
+	}
+	if limit := m.MaxRSS.In(1 << 20); limit > 0 && u.RSS > uint64(limit) {
+		log.Printf("warning: rss %dMB above limit (%dMB)", u.RSS>>20, limit>>20)
+	}
+	if m.MaxGoroutines > 0 && u.Goroutines > m.MaxGoroutines {
+		log.Printf("warning: %d goroutines above limit (%d)", u.Goroutines, m.MaxGoroutines)
+	}
+	if m.MaxFiles > 0 && u.Files > m.MaxFiles {
+		log.Printf("warning: %d open fds above limit (%d)", u.Files, m.MaxFiles)
+	}
+}
+
+func rss() uint64 {
+	buf, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fs := bytes.Fields(buf)
+	if len(fs) < 2 {
+		return 0
+	}
+	pages, _ := strconv.ParseUint(string(fs[1]), 10, 64)
+	return pages * uint64(os.Getpagesize())
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package duplicate
+
+import "time"
+
+func cputime() time.Duration {
+	return 0
+}
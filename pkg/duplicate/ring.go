@@ -0,0 +1,404 @@
+package duplicate
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type poze struct {
+	id     uint64
+	size   int
+	offset int
+	when   time.Time
+	addr   net.Addr
+}
+
+type option func(*ring)
+
+func withDelay(wait time.Duration) option {
+	return func(r *ring) {
+		if wait <= 0 {
+			return
+		}
+		r.wait = wait
+	}
+}
+
+func withJitter(jitter time.Duration, distrib string) option {
+	return func(r *ring) {
+		if jitter <= 0 {
+			return
+		}
+		r.jitter = jitter
+		r.normal = distrib == "normal"
+	}
+}
+
+func withInterval(interval time.Duration) option {
+	return func(r *ring) {
+		if interval <= 0 {
+			return
+		}
+		r.interval = interval
+	}
+}
+
+func withStrict(strict bool) option {
+	return func(r *ring) {
+		r.strict = strict
+	}
+}
+
+func withLatency(h *histogram) option {
+	return func(r *ring) {
+		r.latency = h
+	}
+}
+
+func withAnnotate(annotate bool, src *meta) option {
+	return func(r *ring) {
+		if annotate {
+			r.src = src
+		}
+	}
+}
+
+func withReceived(src *meta) option {
+	return func(r *ring) {
+		r.recv = src
+	}
+}
+
+func withQueue(z int) option {
+	return func(r *ring) {
+		if z <= 0 {
+			return
+		}
+		close(r.queue)
+		r.queue = make(chan poze, z)
+	}
+}
+
+func withClock(c clock) option {
+	return func(r *ring) {
+		r.clock = c
+	}
+}
+
+func withOverflow(policy string, dropped *counter) option {
+	return func(r *ring) {
+		r.overflow, r.dropped = policy, dropped
+	}
+}
+
+// ring keeps the packets of a delayed route in a fixed size buffer until their
+// delay has elapsed. Write can be called from any goroutine and never
+// overwrites the bytes of a packet that has not been read yet: a packet that
+// does not fit in the free space of the buffer is dropped, or waits for room
+// with the block overflow policy. Read must be called from a single goroutine.
+// The space of a packet is released once it has been read or dropped, in the
+// order the packets were written.
+type ring struct {
+	buffer []byte
+	mapped bool
+	clock  clock
+
+	mu     sync.Mutex
+	offset int
+	used   int
+	spans  []span
+	first  uint64
+	room   chan struct{}
+	done   chan struct{}
+	closed bool
+
+	wait   time.Duration
+	jitter time.Duration
+	normal bool
+	strict bool
+
+	embargo *embargo
+
+	interval time.Duration
+	next     time.Time
+
+	latency *histogram
+	src     *meta
+	recv    *meta
+	curr    meta
+
+	once    sync.Once
+	pending sync.WaitGroup
+	queue   chan poze
+
+	overflow string
+	dropped  *counter
+	queued   int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type span struct {
+	size int
+	done bool
+}
+
+func newRing(ctx context.Context, size int, opts ...option) (io.ReadCloser, io.WriteCloser) {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	r := ring{
+		room:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		queue:   make(chan poze, DefaultQueueSize),
+		dropped: new(counter),
+		clock:   wallClock{},
+	}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	for _, o := range opts {
+		o(&r)
+	}
+	if r.buffer == nil {
+		r.buffer = make([]byte, size)
+	}
+	return &r, &r
+}
+
+func (r *ring) Close() error {
+	err := ErrClosed
+	r.once.Do(func() {
+		r.mu.Lock()
+		r.closed = true
+		close(r.done)
+		r.mu.Unlock()
+		r.pending.Wait()
+		close(r.queue)
+		err = nil
+	})
+	return err
+}
+
+func (r *ring) Abort() {
+	r.cancel()
+}
+
+func (r *ring) Write(xs []byte) (int, error) {
+	pz, err := r.alloc(xs)
+	if err != nil || pz.size == 0 {
+		return len(xs), err
+	}
+	if r.recv != nil {
+		pz.when = r.recv.when
+	}
+	if r.src != nil {
+		pz.addr, pz.when = r.src.addr, r.src.when
+	}
+	wait := r.delay()
+	atomic.AddInt64(&r.queued, 1)
+	go func() {
+		defer r.pending.Done()
+		t := r.clock.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-r.ctx.Done():
+			atomic.AddInt64(&r.queued, -1)
+			r.release(pz)
+			return
+		}
+		r.push(pz)
+	}()
+	return len(xs), nil
+}
+
+func (r *ring) alloc(xs []byte) (poze, error) {
+	size := len(xs)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		if r.closed {
+			return poze{}, io.EOF
+		}
+		if size == 0 || size > len(r.buffer) {
+			r.dropped.count(size)
+			return poze{}, nil
+		}
+		if r.used+size <= len(r.buffer) {
+			break
+		}
+		if r.overflow != overflowBlock {
+			r.dropped.count(size)
+			return poze{}, nil
+		}
+		r.mu.Unlock()
+		select {
+		case <-r.room:
+		case <-r.done:
+		case <-r.ctx.Done():
+			r.mu.Lock()
+			r.dropped.count(size)
+			return poze{}, nil
+		}
+		r.mu.Lock()
+	}
+	pz := poze{
+		id:     r.first + uint64(len(r.spans)),
+		size:   size,
+		offset: r.offset,
+		when:   r.clock.Now(),
+	}
+	if n := copy(r.buffer[r.offset:], xs); n < size {
+		r.offset = copy(r.buffer, xs[n:])
+	} else {
+		r.offset = (r.offset + n) % len(r.buffer)
+	}
+	r.used += size
+	r.spans = append(r.spans, span{size: size})
+	r.pending.Add(1)
+	return pz, nil
+}
+
+func (r *ring) release(pz poze) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans[pz.id-r.first].done = true
+	var n int
+	for n < len(r.spans) && r.spans[n].done {
+		r.used -= r.spans[n].size
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	r.spans = r.spans[n:]
+	r.first += uint64(n)
+	select {
+	case r.room <- struct{}{}:
+	default:
+	}
+}
+
+func (r *ring) push(pz poze) {
+	switch r.overflow {
+	case overflowBlock:
+		select {
+		case r.queue <- pz:
+		case <-r.ctx.Done():
+			atomic.AddInt64(&r.queued, -1)
+			r.release(pz)
+		}
+	case overflowOldest:
+		for {
+			select {
+			case r.queue <- pz:
+				return
+			case <-r.ctx.Done():
+				atomic.AddInt64(&r.queued, -1)
+				r.release(pz)
+				return
+			default:
+			}
+			select {
+			case old := <-r.queue:
+				atomic.AddInt64(&r.queued, -1)
+				r.dropped.count(old.size)
+				r.release(old)
+			default:
+			}
+		}
+	default:
+		select {
+		case r.queue <- pz:
+		default:
+			atomic.AddInt64(&r.queued, -1)
+			r.dropped.count(pz.size)
+			r.release(pz)
+		}
+	}
+}
+
+func (r *ring) depth() int {
+	return int(atomic.LoadInt64(&r.queued))
+}
+
+func (r *ring) delay() time.Duration {
+	if r.jitter <= 0 {
+		return r.wait
+	}
+	var j time.Duration
+	if r.normal {
+		j = time.Duration(rand.NormFloat64() * float64(r.jitter))
+	} else {
+		j = time.Duration(rand.Int63n(int64(2*r.jitter)+1)) - r.jitter
+	}
+	if r.strict && j < 0 {
+		j = -j
+	}
+	if w := r.wait + j; w > 0 {
+		return w
+	}
+	return 0
+}
+
+func (r *ring) Read(xs []byte) (int, error) {
+	var (
+		pz poze
+		ok bool
+	)
+	select {
+	case pz, ok = <-r.queue:
+	case <-r.ctx.Done():
+	}
+	if !ok {
+		return 0, r.stop()
+	}
+	atomic.AddInt64(&r.queued, -1)
+	defer r.release(pz)
+	if r.strict {
+		if early := r.clock.Until(r.until(pz)); early > 0 {
+			select {
+			case <-r.clock.After(early):
+			case <-r.ctx.Done():
+				return 0, r.stop()
+			}
+		}
+		if r.embargo != nil {
+			if err := r.embargo.flush(); err != nil {
+				r.embargo.logger.Printf("strict-file: %s: %s", r.embargo.file, err)
+			}
+		}
+	}
+	if r.interval > 0 {
+		if wait := r.clock.Until(r.next); wait > 0 {
+			select {
+			case <-r.clock.After(wait):
+			case <-r.ctx.Done():
+				return 0, r.stop()
+			}
+		}
+		if now := r.clock.Now(); r.next.Before(now) {
+			r.next = now
+		}
+		r.next = r.next.Add(r.interval)
+	}
+	if r.latency != nil {
+		r.latency.Observe(r.clock.Since(pz.when))
+	}
+	if r.src != nil {
+		r.curr = meta{addr: pz.addr, when: pz.when, latency: r.clock.Since(pz.when)}
+	}
+	if len(xs) < pz.size {
+		return 0, io.ErrShortBuffer
+	}
+	n := copy(xs[:pz.size], r.buffer[pz.offset:])
+	if n < pz.size {
+		copy(xs[n:pz.size], r.buffer)
+	}
+	return pz.size, nil
+}
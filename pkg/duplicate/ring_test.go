@@ -0,0 +1,259 @@
+package duplicate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRingWrapAround(t *testing.T) {
+	rg, wg := newRing(context.Background(), 10)
+	defer wg.Close()
+
+	buf := make([]byte, 16)
+	for _, str := range []string{"aaaaaa", "bbbbbbb", "cccccccc"} {
+		if _, err := wg.Write([]byte(str)); err != nil {
+			t.Fatalf("%s: unexpected error: %s", str, err)
+		}
+		n, err := rg.Read(buf)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", str, err)
+		}
+		if got := string(buf[:n]); got != str {
+			t.Fatalf("packet split across the end of the buffer: want %q, got %q", str, got)
+		}
+	}
+	r := rg.(*ring)
+	if r.offset != 1 || r.used != 0 {
+		t.Fatalf("unexpected state: offset %d, used %d", r.offset, r.used)
+	}
+}
+
+func TestRingOverflowDrop(t *testing.T) {
+	var dropped counter
+	rg, wg := newRing(context.Background(), 10, withDelay(time.Hour), withOverflow("", &dropped))
+	defer rg.(*ring).Abort()
+
+	for _, str := range []string{"aaaa", "bbbb", "cccc", "dd"} {
+		wg.Write([]byte(str))
+	}
+	if c := dropped.load(); c.Packets != 1 || c.Bytes != 4 {
+		t.Fatalf("unexpected dropped packets: %+v", c)
+	}
+	if r := rg.(*ring); r.used != 10 {
+		t.Fatalf("bytes used: want 10, got %d", r.used)
+	}
+}
+
+func TestRingOverflowOldest(t *testing.T) {
+	var dropped counter
+	rg, wg := newRing(context.Background(), 64, withQueue(1), withOverflow(overflowOldest, &dropped))
+	r := rg.(*ring)
+	defer wg.Close()
+
+	wg.Write([]byte("aaaa"))
+	waitFor(t, func() bool { return len(r.queue) == 1 })
+	wg.Write([]byte("bbbb"))
+	waitFor(t, func() bool { return dropped.load().Packets == 1 })
+
+	buf := make([]byte, 16)
+	n, err := rg.Read(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := string(buf[:n]); got != "bbbb" {
+		t.Fatalf("oldest packet not dropped: got %q", got)
+	}
+	r.mu.Lock()
+	used := r.used
+	r.mu.Unlock()
+	if used != 0 {
+		t.Fatalf("space of the dropped packet not released: %d bytes used", used)
+	}
+}
+
+func TestRingOverflowBlock(t *testing.T) {
+	var dropped counter
+	rg, wg := newRing(context.Background(), 8, withOverflow(overflowBlock, &dropped))
+	defer wg.Close()
+
+	wg.Write([]byte("aaaaaaaa"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wg.Write([]byte("bbbb"))
+	}()
+	select {
+	case <-done:
+		t.Fatalf("write not blocked by a full buffer")
+	case <-time.After(20 * time.Millisecond):
+	}
+	buf := make([]byte, 16)
+	for _, want := range []string{"aaaaaaaa", "bbbb"} {
+		n, err := rg.Read(buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Fatalf("want %q, got %q", want, got)
+		}
+	}
+	<-done
+	if c := dropped.load(); c.Packets != 0 {
+		t.Fatalf("unexpected dropped packets: %+v", c)
+	}
+}
+
+func TestRingReleaseOrder(t *testing.T) {
+	rg, _ := newRing(context.Background(), 10)
+	r := rg.(*ring)
+
+	first, _ := r.alloc([]byte("aaaa"))
+	second, _ := r.alloc([]byte("bbbb"))
+	r.release(second)
+	if r.used != 8 {
+		t.Fatalf("space released before the packets written earlier: %d bytes used", r.used)
+	}
+	if pz, _ := r.alloc([]byte("cccc")); pz.size != 0 {
+		t.Fatalf("packet written over a packet not yet read")
+	}
+	if !bytes.Equal(r.buffer[:8], []byte("aaaabbbb")) {
+		t.Fatalf("buffer overwritten: %q", r.buffer[:8])
+	}
+	r.release(first)
+	if r.used != 0 || len(r.spans) != 0 {
+		t.Fatalf("space not released: %d bytes used, %d spans", r.used, len(r.spans))
+	}
+}
+
+func TestRingCloseRace(t *testing.T) {
+	for _, abort := range []bool{false, true} {
+		var dropped counter
+		rg, wg := newRing(context.Background(), 32,
+			withDelay(time.Millisecond),
+			withJitter(time.Millisecond, ""),
+			withOverflow(overflowBlock, &dropped),
+		)
+		var writers sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			writers.Add(1)
+			go func(i int) {
+				defer writers.Done()
+				xs := bytes.Repeat([]byte{byte(i)}, 5+i)
+				for {
+					if _, err := wg.Write(xs); errors.Is(err, io.EOF) {
+						return
+					}
+				}
+			}(i)
+		}
+		reader := make(chan error, 1)
+		go func() {
+			buf := make([]byte, 32)
+			for {
+				n, err := rg.Read(buf)
+				if err != nil {
+					reader <- err
+					return
+				}
+				if n != 5+int(buf[0]) || !bytes.Equal(buf[:n], bytes.Repeat(buf[:1], n)) {
+					reader <- errors.New("corrupted packet")
+					return
+				}
+			}
+		}()
+		time.Sleep(20 * time.Millisecond)
+		if abort {
+			go rg.(*ring).Abort()
+		}
+		go wg.Close()
+
+		waitFor(t, func() bool {
+			writers.Wait()
+			return true
+		})
+		select {
+		case err := <-reader:
+			if !errors.Is(err, io.EOF) {
+				t.Fatalf("abort %t: unexpected error: %s", abort, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("abort %t: reader not stopped after close", abort)
+		}
+		wg.Close() // waits for the pending timers
+	}
+}
+
+func TestRingStrictRestart(t *testing.T) {
+	c := newFakeClock()
+	file := filepath.Join(t.TempDir(), "strict")
+	pkt := []byte{0x02, 0x00, 0xc0, 0x07, 0x00, 0x00, 0xff}
+
+	e, err := loadEmbargo(file, c, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rg, wg := newRing(context.Background(), 64, withDelay(time.Minute), withStrict(true), withEmbargo(e), withClock(c))
+	wg.Write(pkt)
+	waitFor(t, func() bool { return c.pending() == 1 })
+	c.Advance(time.Minute)
+	buf := make([]byte, 16)
+	if _, err := rg.Read(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	wg.Close()
+	if _, err := rg.Read(buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	until := c.Now()
+
+	c.mu.Lock()
+	c.now = c.now.Add(-time.Hour)
+	c.mu.Unlock()
+	if e, err = loadEmbargo(file, c, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s := e.state[0x200]; s.Sequence != 7 || !s.Until.Equal(until) {
+		t.Fatalf("unexpected state: %+v", s)
+	}
+	rg, wg = newRing(context.Background(), 64, withDelay(time.Minute), withStrict(true), withEmbargo(e), withClock(c))
+	defer wg.Close()
+	wg.Write(pkt)
+	waitFor(t, func() bool { return c.pending() == 1 })
+	c.Advance(time.Minute)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rg.Read(buf)
+	}()
+	waitFor(t, func() bool { return c.pending() == 1 })
+	select {
+	case <-done:
+		t.Fatalf("packet forwarded before the embargo of the previous run")
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.Advance(time.Hour)
+	<-done
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for !cond() {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("condition not met after 1s")
+	}
+}
@@ -0,0 +1,189 @@
+package duplicate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	outsideDrop   = "drop"
+	outsideBuffer = "buffer"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+type window struct {
+	days     [7]bool
+	from, to int
+	loc      *time.Location
+	off      bool
+}
+
+type schedule struct {
+	windows []window
+	buffer  bool
+}
+
+func newSchedule(active []string, outside string) (*schedule, error) {
+	var s schedule
+	switch outside {
+	case "", outsideDrop:
+	case outsideBuffer:
+		s.buffer = true
+	default:
+		return nil, fmt.Errorf("%s: invalid outside policy (available: drop, buffer)", outside)
+	}
+	if len(active) == 0 {
+		return nil, nil
+	}
+	for _, str := range active {
+		w, err := parseWindow(str)
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	return &s, nil
+}
+
+func (r Route) checkOutside() error {
+	if _, err := newSchedule(r.Active, r.Outside); err != nil {
+		return err
+	}
+	if r.Outside == outsideBuffer && r.Spill != "" && (r.Delay.isSet() || r.Annotate) {
+		return fmt.Errorf("outside: delay and annotate are not supported with a spill directory")
+	}
+	return nil
+}
+
+// spilled tells if the packets of the route wait in its spill directory: when
+// the route is shifted, or when it buffers the packets received while it is
+// not active.
+func (r Route) spilled() bool {
+	return r.Shift.isSet() || (r.Outside == outsideBuffer && r.Spill != "")
+}
+
+func (s *schedule) active(t time.Time) bool {
+	var on, any bool
+	for _, w := range s.windows {
+		if !w.match(t) {
+			if !w.off {
+				any = true
+			}
+			continue
+		}
+		if w.off {
+			return false
+		}
+		on = true
+	}
+	return on || !any
+}
+
+func parseWindow(str string) (window, error) {
+	w := window{
+		from: 0,
+		to:   24 * 60,
+		loc:  time.UTC,
+	}
+	var days bool
+	for _, f := range strings.Fields(str) {
+		switch lower := strings.ToLower(f); {
+		case lower == "off":
+			w.off = true
+		case lower == "on":
+			w.off = false
+		case strings.Contains(f, ":"):
+			from, to, ok := strings.Cut(f, "-")
+			if !ok {
+				return w, fmt.Errorf("%s: invalid time range", str)
+			}
+			var err error
+			if w.from, err = parseClock(from); err != nil {
+				return w, fmt.Errorf("%s: %w", str, err)
+			}
+			if w.to, err = parseClock(to); err != nil {
+				return w, fmt.Errorf("%s: %w", str, err)
+			}
+		case isDays(lower):
+			if err := w.parseDays(lower); err != nil {
+				return w, fmt.Errorf("%s: %w", str, err)
+			}
+			days = true
+		default:
+			loc, err := time.LoadLocation(f)
+			if err != nil {
+				return w, fmt.Errorf("%s: %w", str, err)
+			}
+			w.loc = loc
+		}
+	}
+	if !days {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	return w, nil
+}
+
+func isDays(str string) bool {
+	for _, d := range strings.FieldsFunc(str, func(r rune) bool { return r == ',' || r == '-' }) {
+		if _, ok := weekdays[d]; !ok {
+			return false
+		}
+	}
+	return str != ""
+}
+
+func (w *window) parseDays(str string) error {
+	for _, part := range strings.Split(str, ",") {
+		from, to, ok := strings.Cut(part, "-")
+		if !ok {
+			to = from
+		}
+		first, ok1 := weekdays[from]
+		last, ok2 := weekdays[to]
+		if !ok1 || !ok2 {
+			return fmt.Errorf("%s: invalid days", part)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseClock(str string) (int, error) {
+	t, err := time.Parse("15:04", str)
+	if err != nil {
+		if str == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("%s: invalid time of day", str)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w window) match(t time.Time) bool {
+	t = t.In(w.loc)
+	minute := t.Hour()*60 + t.Minute()
+	if w.from <= w.to {
+		return w.days[t.Weekday()] && minute >= w.from && minute < w.to
+	}
+	if minute >= w.from {
+		return w.days[t.Weekday()]
+	}
+	return minute < w.to && w.days[(t.Weekday()+6)%7]
+}
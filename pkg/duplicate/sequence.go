@@ -0,0 +1,113 @@
+package duplicate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Sequence configures the check of the ccsds sequence counters of the
+// incoming packets.
+type Sequence struct {
+	Mode   string
+	Offset int
+}
+
+type sequence struct {
+	Packets    uint64 `json:"packets"`
+	Gaps       uint64 `json:"gaps"`
+	Missing    uint64 `json:"missing"`
+	Duplicates uint64 `json:"duplicates"`
+
+	last uint16
+}
+
+type tracker struct {
+	ccsds  bool
+	offset int
+	logger *log.Logger
+
+	mu     sync.Mutex
+	states map[uint16]*sequence
+}
+
+func newTracker(s Sequence, logger *log.Logger) (*tracker, error) {
+	t := tracker{
+		logger: logger,
+		offset: s.Offset,
+		states: make(map[uint16]*sequence),
+	}
+	switch s.Mode {
+	case "":
+		return nil, nil
+	case "ccsds":
+		t.ccsds = true
+	case "counter":
+		if s.Offset < 0 {
+			return nil, fmt.Errorf("%d: invalid counter offset", s.Offset)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported sequence mode", s.Mode)
+	}
+	return &t, nil
+}
+
+func (t *tracker) Check(xs []byte) bool {
+	var (
+		id, curr uint16
+		modulo   int
+	)
+	if t.ccsds {
+		if len(xs) < ccsdsHeaderLen {
+			return true
+		}
+		id = uint16(xs[0]&0x07)<<8 | uint16(xs[1])
+		curr = binary.BigEndian.Uint16(xs[2:]) & 0x3FFF
+		modulo = 1 << 14
+	} else {
+		if len(xs) < t.offset+2 {
+			return true
+		}
+		curr = binary.BigEndian.Uint16(xs[t.offset:])
+		modulo = 1 << 16
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.states[id]
+	if !ok {
+		s = &sequence{}
+		t.states[id] = s
+	}
+	s.Packets++
+	valid := true
+	if ok {
+		switch diff := (int(curr) - int(s.last) + modulo) % modulo; diff {
+		case 0:
+			s.Duplicates++
+			valid = false
+			t.logger.Printf("apid %d: duplicate packet (sequence %d)", id, curr)
+		case 1:
+		default:
+			s.Gaps++
+			s.Missing += uint64(diff - 1)
+			valid = false
+			t.logger.Printf("apid %d: %d packet(s) missing (sequence %d -> %d)", id, diff-1, s.last, curr)
+		}
+	}
+	s.last = curr
+	return valid
+}
+
+func (t *tracker) Stats() map[uint16]sequence {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ss := make(map[uint16]sequence, len(t.states))
+	for id, s := range t.states {
+		ss[id] = *s
+	}
+	return ss
+}
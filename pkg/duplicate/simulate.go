@@ -0,0 +1,127 @@
+package duplicate
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Simulate configures the degradation of the stream sent to a route.
+type Simulate struct {
+	Loss      float64  `json:"loss,omitempty"`
+	Duplicate float64  `json:"duplicate,omitempty"`
+	Reorder   int      `json:"reorder,omitempty"`
+	Jitter    Duration `json:"jitter,omitempty"`
+	Corrupt   float64  `json:"corrupt,omitempty"`
+	Bits      int      `json:"bits,omitempty"`
+}
+
+func (s Simulate) isSet() bool {
+	return s.Loss > 0 || s.Duplicate > 0 || s.Reorder > 1 || s.Jitter.isSet() || s.Corrupt > 0
+}
+
+type simulator struct {
+	io.WriteCloser
+	Simulate
+	clock  clock
+	logger *log.Logger
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	pending [][]byte
+	count   uint64
+}
+
+func simulateWriter(w io.WriteCloser, s Simulate, clk clock, logger *log.Logger) io.WriteCloser {
+	if s.Bits <= 0 {
+		s.Bits = 1
+	}
+	return &simulator{
+		WriteCloser: w,
+		Simulate:    s,
+		clock:       clk,
+		logger:      logger,
+	}
+}
+
+func (s *simulator) Write(xs []byte) (int, error) {
+	s.count++
+	if s.Loss > 0 && rand.Float64() < s.Loss {
+		return len(xs), nil
+	}
+	if s.Corrupt > 0 && len(xs) > 0 && rand.Float64() < s.Corrupt {
+		xs = s.corrupt(xs)
+	}
+	count := 1
+	if s.Duplicate > 0 && rand.Float64() < s.Duplicate {
+		count++
+	}
+	for i := 0; i < count; i++ {
+		if err := s.push(xs); err != nil {
+			return 0, err
+		}
+	}
+	return len(xs), nil
+}
+
+// corrupt flips random bits of a copy of the packet and logs their offsets so
+// that the corrupted packets can be found in the output of the decoders.
+func (s *simulator) corrupt(xs []byte) []byte {
+	xs = append([]byte(nil), xs...)
+	offsets := make([]int, s.Bits)
+	for i := range offsets {
+		offsets[i] = rand.Intn(len(xs) * 8)
+		xs[offsets[i]/8] ^= 0x80 >> (offsets[i] % 8)
+	}
+	s.logger.Printf("simulate: packet %d (%d bytes) corrupted at bits %v", s.count, len(xs), offsets)
+	return xs
+}
+
+func (s *simulator) Close() error {
+	for len(s.pending) > 0 {
+		s.emit(s.pop(rand.Intn(len(s.pending))))
+	}
+	s.wg.Wait()
+	return s.WriteCloser.Close()
+}
+
+func (s *simulator) push(xs []byte) error {
+	if s.Reorder <= 1 {
+		return s.emit(xs)
+	}
+	s.pending = append(s.pending, append([]byte(nil), xs...))
+	if len(s.pending) < s.Reorder {
+		return nil
+	}
+	return s.emit(s.pop(rand.Intn(len(s.pending))))
+}
+
+func (s *simulator) pop(i int) []byte {
+	xs := s.pending[i]
+	s.pending = append(s.pending[:i], s.pending[i+1:]...)
+	return xs
+}
+
+func (s *simulator) emit(xs []byte) error {
+	if !s.Jitter.isSet() {
+		return s.write(xs)
+	}
+	xs = append([]byte(nil), xs...)
+	wait := time.Duration(rand.Int63n(int64(s.Jitter.In(time.Millisecond)) + 1))
+
+	s.wg.Add(1)
+	s.clock.AfterFunc(wait, func() {
+		defer s.wg.Done()
+		s.write(xs)
+	})
+	return nil
+}
+
+func (s *simulator) write(xs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.WriteCloser.Write(xs)
+	return err
+}
@@ -0,0 +1,33 @@
+package duplicate
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// SinkFunc opens the connection of a route whose protocol is the name of the
+// sink. Each packet forwarded to the route is given to a single call to Write.
+type SinkFunc func(Route) (io.WriteCloser, error)
+
+var sinks = make(map[string]SinkFunc)
+
+// RegisterSink makes the sink available to the routes under the given protocol.
+// It is meant to be called from an init function and panics if the protocol is
+// already registered.
+func RegisterSink(proto string, fn SinkFunc) {
+	if _, ok := sinks[proto]; ok {
+		panic(fmt.Sprintf("%s: sink already registered", proto))
+	}
+	sinks[proto] = fn
+}
+
+// Sinks gives the names of the registered sinks.
+func Sinks() []string {
+	var vs []string
+	for n := range sinks {
+		vs = append(vs, n)
+	}
+	sort.Strings(vs)
+	return vs
+}
@@ -0,0 +1,155 @@
+package duplicate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultDialRetries = 5
+	DefaultDialBackoff = 100 * time.Millisecond
+)
+
+func dialRetry(r Route) (io.WriteCloser, error) {
+	wait := DefaultDialBackoff
+	for i := 0; ; i++ {
+		w, err := dial(r)
+		if err == nil || !exhausted(err) {
+			return w, err
+		}
+		if i >= DefaultDialRetries {
+			return nil, describe(err)
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func exhausted(err error) bool {
+	for _, e := range []error{syscall.EMFILE, syscall.ENFILE, syscall.EADDRNOTAVAIL, syscall.ENOBUFS} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(err error) error {
+	switch {
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return fmt.Errorf("%w: file descriptors exhausted (raise the limit of open files or share the sockets of the routes)", err)
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		return fmt.Errorf("%w: ephemeral ports exhausted (widen the local port range or share the sockets of the routes)", err)
+	case errors.Is(err, syscall.ENOBUFS):
+		return fmt.Errorf("%w: kernel buffers exhausted", err)
+	default:
+		return err
+	}
+}
+
+type socketKey struct {
+	addr     string
+	network  string
+	local    string
+	ifi      string
+	ttl      int
+	tos      int
+	mttl     int
+	loopback string
+}
+
+func keyOf(r Route) socketKey {
+	k := socketKey{
+		addr:    r.Addr,
+		network: r.Network,
+		local:   net.JoinHostPort(r.LocalAddr, strconv.Itoa(r.LocalPort)),
+		ifi:     r.Ifi,
+		ttl:     r.TTL,
+		tos:     r.TOS,
+		mttl:    r.MulticastTTL,
+	}
+	if r.Loopback != nil {
+		k.loopback = fmt.Sprint(*r.Loopback)
+	}
+	return k
+}
+
+type socket struct {
+	net.Conn
+	key  socketKey
+	refs int
+}
+
+var sockets = struct {
+	sync.Mutex
+	conns map[socketKey]*socket
+}{
+	conns: make(map[socketKey]*socket),
+}
+
+type sharedConn struct {
+	*socket
+	once sync.Once
+}
+
+func (r Route) checkShare() error {
+	if !r.Share {
+		return nil
+	}
+	if r.Proto != "" && r.Proto != DefaultProtocol {
+		return fmt.Errorf("%s: sockets can only be shared with %s", r.Proto, DefaultProtocol)
+	}
+	if r.GSO > 0 {
+		return fmt.Errorf("share: not supported with gso")
+	}
+	if r.Compress != "" {
+		return fmt.Errorf("share: not supported with compress")
+	}
+	return nil
+}
+
+// dialShared gives a connection to the socket shared by the routes with the
+// same key. The writers of dial (gso, compress) are not applied: checkShare
+// rejects them.
+func dialShared(r Route) (io.WriteCloser, error) {
+	if err := r.checkShare(); err != nil {
+		return nil, err
+	}
+	sockets.Lock()
+	defer sockets.Unlock()
+
+	k := keyOf(r)
+	s, ok := sockets.conns[k]
+	if !ok {
+		c, err := dialSocket(DefaultProtocol, r)
+		if err != nil {
+			return nil, err
+		}
+		s = &socket{Conn: c, key: k}
+		sockets.conns[k] = s
+	}
+	s.refs++
+	return &sharedConn{socket: s}, nil
+}
+
+func (c *sharedConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		sockets.Lock()
+		defer sockets.Unlock()
+
+		c.refs--
+		if c.refs > 0 {
+			return
+		}
+		delete(sockets.conns, c.key)
+		err = c.Conn.Close()
+	})
+	return err
+}
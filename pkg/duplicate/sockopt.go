@@ -0,0 +1,38 @@
+package duplicate
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ErrUnsupported is returned for the options not available on the platform.
+var ErrUnsupported = errors.New("not supported on this platform")
+
+type sockopt func(int) error
+
+func setSockopts(c net.Conn, opts []sockopt) error {
+	if len(opts) == 0 {
+		return nil
+	}
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		for _, set := range opts {
+			if serr = set(int(fd)); serr != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package duplicate
+
+import (
+	"fmt"
+	"net"
+)
+
+func setInt(level, name, value int) sockopt {
+	return func(int) error {
+		return ErrUnsupported
+	}
+}
+
+// reuseAddr does nothing: the local address is still bound but several routes
+// can not share the same local port.
+func reuseAddr(int) error {
+	return nil
+}
+
+func routeSockopts(r Route, remote net.Addr) ([]sockopt, error) {
+	if r.TTL > 0 || r.TOS > 0 || r.MulticastTTL > 0 || r.Loopback != nil || r.Ifi != "" {
+		return nil, fmt.Errorf("ttl, tos and multicast options: %w", ErrUnsupported)
+	}
+	return nil, nil
+}
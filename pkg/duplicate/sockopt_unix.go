@@ -0,0 +1,117 @@
+//go:build unix
+
+package duplicate
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+func setInt(level, name, value int) sockopt {
+	return func(fd int) error {
+		return syscall.SetsockoptInt(fd, level, name, value)
+	}
+}
+
+func reuseAddr(fd int) error {
+	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+}
+
+func routeSockopts(r Route, remote net.Addr) ([]sockopt, error) {
+	var (
+		ip   net.IP
+		zone string
+	)
+	switch a := remote.(type) {
+	case *net.UDPAddr:
+		ip, zone = a.IP, a.Zone
+	case *net.TCPAddr:
+		ip, zone = a.IP, a.Zone
+	default:
+		return nil, nil
+	}
+	var (
+		opts []sockopt
+		ipv4 = ip.To4() != nil
+	)
+	if r.TTL > 0 {
+		switch {
+		case ip.IsMulticast() && r.MulticastTTL > 0:
+		case ipv4 && ip.IsMulticast():
+			opts = append(opts, setInt(syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, r.TTL))
+		case ipv4:
+			opts = append(opts, setInt(syscall.IPPROTO_IP, syscall.IP_TTL, r.TTL))
+		case ip.IsMulticast():
+			opts = append(opts, setInt(syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, r.TTL))
+		default:
+			opts = append(opts, setInt(syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, r.TTL))
+		}
+	}
+	if r.TOS > 0 {
+		if ipv4 {
+			opts = append(opts, setInt(syscall.IPPROTO_IP, syscall.IP_TOS, r.TOS))
+		} else {
+			opts = append(opts, setInt(syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, r.TOS))
+		}
+	}
+	if !ip.IsMulticast() {
+		return opts, nil
+	}
+	if r.MulticastTTL > 0 {
+		if ipv4 {
+			opts = append(opts, setInt(syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, r.MulticastTTL))
+		} else {
+			opts = append(opts, setInt(syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, r.MulticastTTL))
+		}
+	}
+	if r.Loopback != nil {
+		loop := 0
+		if *r.Loopback {
+			loop = 1
+		}
+		if ipv4 {
+			opts = append(opts, setInt(syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, loop))
+		} else {
+			opts = append(opts, setInt(syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, loop))
+		}
+	}
+	if r.Ifi == "" {
+		r.Ifi = zone
+	}
+	if r.Ifi != "" {
+		set, err := multicastIf(r.Ifi, ipv4)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, set)
+	}
+	return opts, nil
+}
+
+func multicastIf(name string, ipv4 bool) (sockopt, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if !ipv4 {
+		return setInt(syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, ifi.Index), nil
+	}
+	as, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range as {
+		n, ok := a.(*net.IPNet)
+		if !ok || n.IP.To4() == nil {
+			continue
+		}
+		var addr [4]byte
+		copy(addr[:], n.IP.To4())
+		set := func(fd int) error {
+			return syscall.SetsockoptInet4Addr(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)
+		}
+		return set, nil
+	}
+	return nil, fmt.Errorf("%s: no ipv4 address", name)
+}
@@ -0,0 +1,323 @@
+package duplicate
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	spillHeaderLen   = 12
+	spillExtension   = ".spill"
+	spillPosition    = "position"
+	spillSegmentSize = 64 << 20
+	spillSegmentTime = 10 * time.Minute
+)
+
+type segment struct {
+	file  string
+	count int64
+}
+
+type spill struct {
+	dir   string
+	shift time.Duration
+	recv  *meta
+	clock clock
+
+	mu       sync.Mutex
+	segments []*segment
+	file     *os.File
+	opened   time.Time
+	size     int
+	seq      int
+	closed   bool
+	notify   chan struct{}
+
+	reader *os.File
+	offset int64
+	read   int64
+	resume string
+	buf    []byte
+
+	queued  int64
+	dropped *counter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r Route) checkShift() error {
+	if !r.Shift.isSet() {
+		return nil
+	}
+	if r.Spill == "" {
+		return fmt.Errorf("shift: spill directory not set")
+	}
+	if r.Delay.isSet() || r.Annotate {
+		return fmt.Errorf("shift: delay and annotate are not supported with shift")
+	}
+	return nil
+}
+
+func newSpill(ctx context.Context, dir string, shift time.Duration, clk clock, recv *meta, dropped *counter) (io.ReadCloser, io.WriteCloser, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+	s := spill{
+		dir:     dir,
+		shift:   shift,
+		recv:    recv,
+		clock:   clk,
+		notify:  make(chan struct{}, 1),
+		buf:     make([]byte, spillHeaderLen),
+		dropped: dropped,
+	}
+	if err := s.recover(); err != nil {
+		return nil, nil, err
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	return &s, &s, nil
+}
+
+func (s *spill) recover() error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+spillExtension))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		n, err := scanSegment(f)
+		if err != nil {
+			return fmt.Errorf("spill: %w", err)
+		}
+		if n == 0 {
+			os.Remove(f)
+			continue
+		}
+		s.segments = append(s.segments, &segment{file: f, count: n})
+		s.queued += n
+		fmt.Sscanf(strings.TrimSuffix(filepath.Base(f), spillExtension), "%d", &s.seq)
+	}
+	buf, err := os.ReadFile(filepath.Join(s.dir, spillPosition))
+	if err != nil || len(s.segments) == 0 {
+		return nil
+	}
+	var (
+		file   string
+		offset int64
+		read   int64
+	)
+	if n, _ := fmt.Sscanf(string(buf), "%s %d %d", &file, &offset, &read); n == 3 && file == filepath.Base(s.segments[0].file) && read <= s.segments[0].count {
+		s.resume, s.offset, s.read = s.segments[0].file, offset, read
+		s.queued -= read
+	}
+	return nil
+}
+
+func scanSegment(file string) (int64, error) {
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	i, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		offset int64
+		count  int64
+		buf    = make([]byte, spillHeaderLen)
+	)
+	for offset+spillHeaderLen <= i.Size() {
+		if _, err := f.ReadAt(buf, offset); err != nil {
+			return 0, err
+		}
+		next := offset + spillHeaderLen + int64(binary.BigEndian.Uint32(buf[8:]))
+		if next > i.Size() {
+			break
+		}
+		offset = next
+		count++
+	}
+	return count, f.Truncate(offset)
+}
+
+func (s *spill) Write(xs []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, io.EOF
+	}
+	if s.file == nil || s.expired(len(xs)) {
+		if err := s.roll(); err != nil {
+			s.dropped.count(len(xs))
+			return len(xs), nil
+		}
+	}
+	buf := getPacket(spillHeaderLen + len(xs))
+	defer putPacket(buf)
+	when := s.clock.Now()
+	if s.recv != nil {
+		when = s.recv.when
+	}
+	binary.BigEndian.PutUint64(*buf, uint64(when.UnixNano()))
+	binary.BigEndian.PutUint32((*buf)[8:], uint32(len(xs)))
+	copy((*buf)[spillHeaderLen:], xs)
+	if _, err := s.file.Write(*buf); err != nil {
+		s.dropped.count(len(xs))
+		return len(xs), nil
+	}
+	s.size += len(*buf)
+	atomic.AddInt64(&s.segments[len(s.segments)-1].count, 1)
+	atomic.AddInt64(&s.queued, 1)
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return len(xs), nil
+}
+
+func (s *spill) expired(n int) bool {
+	if s.clock.Since(s.opened) >= spillSegmentTime {
+		return true
+	}
+	return s.size > 0 && s.size+spillHeaderLen+n > spillSegmentSize
+}
+
+func (s *spill) roll() error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	s.seq++
+	file := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.seq, spillExtension))
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	s.file, s.opened, s.size = f, s.clock.Now(), 0
+	s.segments = append(s.segments, &segment{file: file})
+	return nil
+}
+
+func (s *spill) Read(xs []byte) (int, error) {
+	for {
+		if s.ctx.Err() != nil {
+			return 0, s.save()
+		}
+		seg, last, err := s.current()
+		if err != nil {
+			return 0, err
+		}
+		if seg == nil || s.read >= atomic.LoadInt64(&seg.count) {
+			if seg != nil && !last {
+				s.next(seg)
+				continue
+			}
+			select {
+			case <-s.notify:
+				continue
+			case <-s.ctx.Done():
+				return 0, s.save()
+			}
+		}
+		if _, err := s.reader.ReadAt(s.buf, s.offset); err != nil {
+			return 0, err
+		}
+		var (
+			when = time.Unix(0, int64(binary.BigEndian.Uint64(s.buf)))
+			size = int(binary.BigEndian.Uint32(s.buf[8:]))
+		)
+		if size > len(xs) {
+			return 0, io.ErrShortBuffer
+		}
+		if wait := s.clock.Until(when.Add(s.shift)); wait > 0 {
+			select {
+			case <-s.clock.After(wait):
+			case <-s.ctx.Done():
+				return 0, s.save()
+			}
+		}
+		n, err := s.reader.ReadAt(xs[:size], s.offset+spillHeaderLen)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		s.offset += int64(spillHeaderLen + size)
+		s.read++
+		atomic.AddInt64(&s.queued, -1)
+		return n, nil
+	}
+}
+
+func (s *spill) current() (*segment, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.segments) == 0 {
+		return nil, true, nil
+	}
+	seg := s.segments[0]
+	if s.reader == nil {
+		f, err := os.Open(seg.file)
+		if err != nil {
+			return nil, false, err
+		}
+		if seg.file != s.resume {
+			s.offset, s.read = 0, 0
+		}
+		s.reader, s.resume = f, ""
+	}
+	return seg, len(s.segments) == 1, nil
+}
+
+func (s *spill) next(seg *segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reader.Close()
+	s.reader = nil
+	s.segments = s.segments[1:]
+	os.Remove(seg.file)
+}
+
+func (s *spill) save() error {
+	file := filepath.Join(s.dir, spillPosition)
+	if s.reader == nil {
+		os.Remove(file)
+		return io.EOF
+	}
+	str := fmt.Sprintf("%s %d %d\n", filepath.Base(s.reader.Name()), s.offset, s.read)
+	os.WriteFile(file, []byte(str), 0644)
+	return io.EOF
+}
+
+func (s *spill) depth() int {
+	return int(atomic.LoadInt64(&s.queued))
+}
+
+func (s *spill) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.closed = true
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.cancel()
+	return nil
+}
+
+func (s *spill) Abort() {
+	s.cancel()
+}
@@ -0,0 +1,60 @@
+package duplicate
+
+import "fmt"
+
+func (t Tenant) checkSplice() error {
+	if err := spliceable(); err != nil {
+		return err
+	}
+	ls := t.listeners()
+	if len(ls) != 1 {
+		return fmt.Errorf("only one listener supported")
+	}
+	l := ls[0]
+	if l.Proto != "tcp" || l.Timestamp || l.Concurrent || l.Detect || l.Decompress != "" {
+		return fmt.Errorf("%s: only a plain tcp listener is supported", l.Remote)
+	}
+	if t.Certificate.isSet() {
+		return fmt.Errorf("tls not supported")
+	}
+	if t.Envelope != "" || t.Trailer != "" || t.FEC || t.Latency || t.Decryption.isSet() || t.Arbitration.Mode != "" {
+		return fmt.Errorf("envelope, trailer, fec, decryption and arbitration not supported")
+	}
+	if t.MinSize > 0 || t.MaxSize > 0 || t.Sequence.Mode != "" || t.Archive.isSet() || t.Capture.isSet() || t.Tap.isSet() || t.Shared.isSet() {
+		return fmt.Errorf("min-size, max-size, sequence, archive, capture, tap and shared-buffer not supported")
+	}
+	if len(t.Groups) > 0 {
+		return fmt.Errorf("groups not supported")
+	}
+	for _, r := range t.Routes {
+		if err := r.checkSplice(l.Framing); err != nil {
+			return fmt.Errorf("%s: %w", r.Addr, err)
+		}
+	}
+	return nil
+}
+
+func (r Route) checkSplice(framing string) error {
+	if r.Proto != "tcp" || r.Listen || r.Share || len(r.Members) > 0 {
+		return fmt.Errorf("only plain tcp routes are supported")
+	}
+	if (r.Framing == "length") != (framing == "length") {
+		return fmt.Errorf("framing of the route differs from the framing of the listener")
+	}
+	if r.Delay.isSet() || r.Shift.isSet() || r.Buffer.isSet() || r.Annotate || r.Envelope != "" {
+		return fmt.Errorf("delay, shift, buffer, annotate and envelope not supported")
+	}
+	if r.Transform != "" || r.Strip > 0 || r.Prepend != "" || r.Compress != "" {
+		return fmt.Errorf("transform, strip-prefix, prepend and compress not supported")
+	}
+	if r.Encryption.isSet() || r.Trailer != "" || r.FEC > 0 || r.Certificate.isSet() {
+		return fmt.Errorf("encryption, trailer, fec and tls not supported")
+	}
+	if len(r.Apids) > 0 || r.MinSize > 0 || r.MaxSize > 0 || len(r.Active) > 0 || r.OnPause == outsideBuffer {
+		return fmt.Errorf("apid, min-size, max-size, active and on-pause buffer not supported")
+	}
+	if r.Simulate.isSet() || r.Heartbeat.isSet() || r.LatencyProbe.isSet() || r.Probe.isSet() || r.Tap.isSet() || r.Workers > 1 {
+		return fmt.Errorf("simulate, heartbeat, latency-probe, probe, tap and workers not supported")
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+//go:build linux
+
+package duplicate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	spliceChunk    = 1 << 16
+	spliceMove     = 0x1
+	spliceNonblock = 0x2
+)
+
+func spliceable() error {
+	return nil
+}
+
+type spliceRoute struct {
+	*route
+	conn *net.TCPConn
+	pipe [2]int
+}
+
+type splicer struct {
+	net.Listener
+	x       *relay
+	routes  []*spliceRoute
+	accept  func(net.Addr) bool
+	scratch []byte
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+func (x *relay) spliceRoutes(t Tenant) (*splicer, error) {
+	s := splicer{
+		x:       x,
+		scratch: make([]byte, spliceChunk),
+	}
+	if len(t.Allow) > 0 || len(t.Deny) > 0 {
+		r, err := aclReader(nil, t.Allow, t.Deny, &x.rejected)
+		if err != nil {
+			return nil, err
+		}
+		s.accept = r.(*acl).accept
+	}
+	l := t.listeners()[0]
+	n, err := network("tcp", l.Network)
+	if err != nil {
+		return nil, err
+	}
+	if s.Listener = inheritTCP(l.Remote); s.Listener == nil {
+		if s.Listener, err = net.Listen(n, l.Remote); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range t.Routes {
+		if r.Name == "" {
+			r.Name = r.Addr
+		}
+		rt := spliceRoute{
+			route: &route{Route: r, abort: func() {}},
+		}
+		if r.Paused {
+			rt.Pause()
+		}
+		if err := syscall.Pipe2(rt.pipe[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+			s.Close()
+			s.release()
+			return nil, err
+		}
+		s.routes = append(s.routes, &rt)
+		x.routes = append(x.routes, rt.route)
+		if err := rt.dial(); err != nil && !r.Lazy {
+			s.Close()
+			s.release()
+			return nil, fmt.Errorf("%s: %w", r.Name, err)
+		}
+	}
+	return &s, nil
+}
+
+func (s *splicer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return net.ErrClosed
+	}
+	s.closed = true
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	return s.Listener.Close()
+}
+
+func (s *splicer) release() {
+	for _, rt := range s.routes {
+		if rt.conn != nil {
+			rt.conn.Close()
+		}
+		syscall.Close(rt.pipe[0])
+		syscall.Close(rt.pipe[1])
+	}
+}
+
+func (s *splicer) run() error {
+	defer s.release()
+	defer s.Close()
+	for {
+		c, err := s.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			continue
+		}
+		if s.accept != nil && !s.accept(c.RemoteAddr()) {
+			s.x.rejected.count(0)
+			c.Close()
+			continue
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return nil
+		}
+		s.conn = c
+		s.mu.Unlock()
+
+		err = s.copy(c.(*net.TCPConn))
+		c.Close()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			s.x.logger.Printf("splice: %s: %s", c.RemoteAddr(), err)
+		}
+	}
+}
+
+func (s *splicer) copy(c *net.TCPConn) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var (
+		first = s.routes[0]
+		n     int
+	)
+	for {
+		var serr error
+		err := rc.Read(func(fd uintptr) bool {
+			n, serr = splice(int(fd), first.pipe[1], spliceChunk)
+			return !errors.Is(serr, syscall.EAGAIN)
+		})
+		if err == nil {
+			err = serr
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		s.x.in.count(n)
+		for _, rt := range s.routes[1:] {
+			if err := tee(first.pipe[0], rt.pipe[1], n); err != nil {
+				return err
+			}
+		}
+		for _, rt := range s.routes {
+			s.forward(rt, n)
+		}
+	}
+}
+
+func (s *splicer) forward(rt *spliceRoute, n int) {
+	if rt.Paused() {
+		rt.dropped.count(n)
+		s.discard(rt, n)
+		return
+	}
+	if rt.conn == nil {
+		if err := rt.dial(); err != nil {
+			rt.dropped.count(n)
+			s.discard(rt, n)
+			return
+		}
+	}
+	wc, err := rt.conn.SyscallConn()
+	if err != nil {
+		s.fail(rt, n)
+		return
+	}
+	for moved := 0; moved < n; {
+		var (
+			m    int
+			serr error
+		)
+		err := wc.Write(func(fd uintptr) bool {
+			m, serr = splice(rt.pipe[0], int(fd), n-moved)
+			return !errors.Is(serr, syscall.EAGAIN)
+		})
+		if err == nil {
+			err = serr
+		}
+		if err != nil || m == 0 {
+			s.fail(rt, n-moved)
+			return
+		}
+		moved += m
+	}
+	rt.sent.count(n)
+}
+
+func (s *splicer) fail(rt *spliceRoute, n int) {
+	s.discard(rt, n)
+	rt.conn.Close()
+	rt.conn = nil
+	rt.dropped.count(n)
+	atomic.AddUint64(&rt.errors, 1)
+}
+
+func (s *splicer) discard(rt *spliceRoute, n int) {
+	for n > 0 {
+		m, err := syscall.Read(rt.pipe[0], s.scratch[:min(n, len(s.scratch))])
+		if err != nil || m <= 0 {
+			return
+		}
+		n -= m
+	}
+}
+
+func (rt *spliceRoute) dial() error {
+	c, err := dialRetry(rt.Route)
+	if err != nil {
+		return err
+	}
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		c.Close()
+		return io.ErrClosedPipe
+	}
+	rt.conn = tc
+	return nil
+}
+
+func splice(in, out, n int) (int, error) {
+	m, err := syscall.Splice(in, nil, out, nil, n, spliceMove|spliceNonblock)
+	return int(m), err
+}
+
+func tee(in, out, n int) error {
+	for n > 0 {
+		m, err := syscall.Tee(in, out, n, 0)
+		if err != nil {
+			return err
+		}
+		n -= int(m)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package duplicate
+
+import "fmt"
+
+type splicer struct{}
+
+func spliceable() error {
+	return ErrUnsupported
+}
+
+func (x *relay) spliceRoutes(t Tenant) (*splicer, error) {
+	return nil, fmt.Errorf("splice: %w", ErrUnsupported)
+}
+
+func (s *splicer) run() error {
+	return nil
+}
+
+func (s *splicer) Close() error {
+	return nil
+}
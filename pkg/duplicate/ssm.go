@@ -0,0 +1,18 @@
+package duplicate
+
+import (
+	"fmt"
+	"net"
+)
+
+func parseSources(srcs []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, s := range srcs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%s: invalid source address", s)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
@@ -0,0 +1,90 @@
+//go:build linux
+
+package duplicate
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// values of MCAST_JOIN_SOURCE_GROUP and sizeof(struct sockaddr_storage) on linux.
+const (
+	mcastJoinSourceGroup  = 0x2e
+	sizeofSockaddrStorage = 128
+)
+
+func listenSSM(n string, addr *net.UDPAddr, ifi *net.Interface, sources []string) (*net.UDPConn, error) {
+	ips, err := parseSources(sources)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		level = syscall.IPPROTO_IPV6
+		index int
+	)
+	if addr.IP.To4() != nil {
+		level = syscall.IPPROTO_IP
+	}
+	if ifi != nil {
+		index = ifi.Index
+	}
+	var opts []sockopt
+	for _, ip := range ips {
+		if (ip.To4() != nil) != (level == syscall.IPPROTO_IP) {
+			return nil, fmt.Errorf("%s: source and group %s are not of the same family", ip, addr.IP)
+		}
+		req := groupSourceReq(index, addr.IP, ip)
+		opts = append(opts, func(fd int) error {
+			return syscall.SetsockoptString(fd, level, mcastJoinSourceGroup, req)
+		})
+	}
+	cfg := net.ListenConfig{
+		Control: func(_, _ string, raw syscall.RawConn) error {
+			var serr error
+			err := raw.Control(func(fd uintptr) {
+				serr = setInt(syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)(int(fd))
+			})
+			if err != nil {
+				return err
+			}
+			return serr
+		},
+	}
+	c, err := cfg.ListenPacket(context.Background(), n, addr.String())
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*net.UDPConn)
+	if err := setSockopts(conn, opts); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: join source-specific group: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// groupSourceReq encodes a struct group_source_req: the interface index
+// followed by two sockaddr_storage aligned on the size of a long.
+func groupSourceReq(index int, group, source net.IP) string {
+	var (
+		offset = strconv.IntSize / 8
+		buf    = make([]byte, offset+2*sizeofSockaddrStorage)
+	)
+	binary.NativeEndian.PutUint32(buf, uint32(index))
+	sockaddr(buf[offset:], group)
+	sockaddr(buf[offset+sizeofSockaddrStorage:], source)
+	return string(buf)
+}
+
+func sockaddr(buf []byte, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		binary.NativeEndian.PutUint16(buf, syscall.AF_INET)
+		copy(buf[4:], ip4)
+		return
+	}
+	binary.NativeEndian.PutUint16(buf, syscall.AF_INET6)
+	copy(buf[8:], ip.To16())
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package duplicate
+
+import (
+	"fmt"
+	"net"
+)
+
+func listenSSM(n string, addr *net.UDPAddr, ifi *net.Interface, sources []string) (*net.UDPConn, error) {
+	return nil, fmt.Errorf("%s: source-specific multicast: %w", addr, ErrUnsupported)
+}
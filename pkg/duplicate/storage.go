@@ -0,0 +1,71 @@
+package duplicate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Storage configures the directory where the relative paths are resolved.
+type Storage struct {
+	Directory string
+	Disabled  bool
+}
+
+func (s Storage) check() error {
+	if s.Disabled || s.Directory == "" {
+		return nil
+	}
+	i, err := os.Stat(s.Directory)
+	if err != nil {
+		return err
+	}
+	if !i.IsDir() {
+		return fmt.Errorf("%s: not a directory", s.Directory)
+	}
+	return nil
+}
+
+func (s Storage) resolve(file string) string {
+	if file == "" || s.Disabled {
+		return ""
+	}
+	if s.Directory == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(s.Directory, file)
+}
+
+func (s Storage) tenant(t Tenant) (Tenant, error) {
+	t.Log = s.resolve(t.Log)
+	if t.Archive.isSet() {
+		if t.Archive.Directory = s.resolve(t.Archive.Directory); t.Archive.Directory == "" {
+			return t, fmt.Errorf("%s: archive: storage is disabled", t)
+		}
+	}
+	t.Capture.Directory = s.resolve(t.Capture.Directory)
+	t.Tap.File = s.resolve(t.Tap.File)
+	t.Routes = append([]Route(nil), t.Routes...)
+	t.Groups = append([]Route(nil), t.Groups...)
+	for _, rs := range [][]Route{t.Routes, t.Groups} {
+		for i := range rs {
+			rs[i].Tap.File = s.resolve(rs[i].Tap.File)
+			if rs[i].Spill != "" {
+				if rs[i].Spill = s.resolve(rs[i].Spill); rs[i].Spill == "" {
+					return t, fmt.Errorf("%s: spill: storage is disabled", t)
+				}
+			}
+			if rs[i].BufferFile != "" {
+				if rs[i].BufferFile = s.resolve(rs[i].BufferFile); rs[i].BufferFile == "" {
+					return t, fmt.Errorf("%s: buffer-file: storage is disabled", t)
+				}
+			}
+			if rs[i].StrictFile != "" {
+				if rs[i].StrictFile = s.resolve(rs[i].StrictFile); rs[i].StrictFile == "" {
+					return t, fmt.Errorf("%s: strict-file: storage is disabled", t)
+				}
+			}
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,153 @@
+package duplicate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const strictSaveInterval = time.Second
+
+func (r Route) checkStrict() error {
+	if r.StrictFile == "" {
+		return nil
+	}
+	if !r.Strict || !r.Delay.isSet() {
+		return fmt.Errorf("strict-file: requires strict and delay")
+	}
+	return nil
+}
+
+type embargoState struct {
+	Sequence uint16    `json:"sequence"`
+	Until    time.Time `json:"until"`
+}
+
+// embargo keeps, per APID, the sequence count of the last packet forwarded by
+// a strict route and the end of its embargo. The state saved by the previous
+// run gives the time before which no packet of an APID is forwarded, even if
+// the clock went back meanwhile.
+type embargo struct {
+	file   string
+	clock  clock
+	logger *log.Logger
+
+	mu    sync.Mutex
+	floor map[uint16]time.Time
+	state map[uint16]embargoState
+	saved time.Time
+}
+
+func loadEmbargo(file string, clk clock, logger *log.Logger) (*embargo, error) {
+	e := embargo{
+		file:   file,
+		clock:  clk,
+		logger: logger,
+		floor:  make(map[uint16]time.Time),
+		state:  make(map[uint16]embargoState),
+	}
+	buf, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(buf) > 0 {
+		if err := json.Unmarshal(buf, &e.state); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	for apid, s := range e.state {
+		e.floor[apid] = s.Until
+	}
+	return &e, e.save()
+}
+
+// hold gives the time at which the packet can be forwarded and records it as
+// the last one of its APID.
+func (e *embargo) hold(apid, seq uint16, until time.Time) time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if f := e.floor[apid]; f.After(until) {
+		until = f
+	}
+	e.state[apid] = embargoState{Sequence: seq, Until: until}
+	return until
+}
+
+func (e *embargo) flush() error {
+	e.mu.Lock()
+	due := e.clock.Since(e.saved) >= strictSaveInterval
+	e.mu.Unlock()
+	if !due {
+		return nil
+	}
+	return e.save()
+}
+
+func (e *embargo) save() error {
+	e.mu.Lock()
+	buf, err := json.Marshal(e.state)
+	e.saved = e.clock.Now()
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(e.file), filepath.Base(e.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), e.file)
+}
+
+func withEmbargo(e *embargo) option {
+	return func(r *ring) {
+		r.embargo = e
+	}
+}
+
+// until gives the time before which the packet is not forwarded by a strict
+// ring.
+func (r *ring) until(pz poze) time.Time {
+	until := pz.when.Add(r.wait)
+	if r.embargo == nil || pz.size < ccsdsHeaderLen {
+		return until
+	}
+	var hdr [4]byte
+	for i := range hdr {
+		hdr[i] = r.buffer[(pz.offset+i)%len(r.buffer)]
+	}
+	var (
+		apid = binary.BigEndian.Uint16(hdr[:]) & 0x07ff
+		seq  = binary.BigEndian.Uint16(hdr[2:]) & 0x3fff
+	)
+	return r.embargo.hold(apid, seq, until)
+}
+
+// stop saves the state of the embargo before the ring is released. A failure
+// to save is only logged since stop has to give io.EOF to the reader.
+func (r *ring) stop() error {
+	if r.embargo != nil {
+		if err := r.embargo.save(); err != nil {
+			r.embargo.logger.Printf("strict-file: %s: %s", r.embargo.file, err)
+		}
+	}
+	return r.unmap()
+}
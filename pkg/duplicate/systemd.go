@@ -0,0 +1,156 @@
+package duplicate
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const listenFdsStart = 3
+
+var activation struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files []*os.File
+}
+
+func activated() []*os.File {
+	activation.once.Do(func() {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || n <= 0 {
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < n; i++ {
+			name := "LISTEN_FD_" + strconv.Itoa(listenFdsStart+i)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			activation.files = append(activation.files, os.NewFile(uintptr(listenFdsStart+i), name))
+		}
+	})
+	return activation.files
+}
+
+func inherit(match func(*os.File) bool) {
+	activation.mu.Lock()
+	defer activation.mu.Unlock()
+	for i, f := range activated() {
+		if f != nil && match(f) {
+			activation.files[i] = nil
+			f.Close()
+			return
+		}
+	}
+}
+
+func inheritUDP(addr *net.UDPAddr) *net.UDPConn {
+	var conn *net.UDPConn
+	inherit(func(f *os.File) bool {
+		c, err := net.FilePacketConn(f)
+		if err != nil {
+			return false
+		}
+		u, ok := c.(*net.UDPConn)
+		if ok && sameAddr(u.LocalAddr(), addr.IP, addr.Port) {
+			conn = u
+			return true
+		}
+		c.Close()
+		return false
+	})
+	return conn
+}
+
+func inheritTCP(addr string) net.Listener {
+	a, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil
+	}
+	var list net.Listener
+	inherit(func(f *os.File) bool {
+		s, err := net.FileListener(f)
+		if err != nil {
+			return false
+		}
+		if sameAddr(s.Addr(), a.IP, a.Port) {
+			list = s
+			return true
+		}
+		s.Close()
+		return false
+	})
+	return list
+}
+
+func sameAddr(local net.Addr, ip net.IP, port int) bool {
+	var (
+		lip   net.IP
+		lport int
+	)
+	switch a := local.(type) {
+	case *net.UDPAddr:
+		lip, lport = a.IP, a.Port
+	case *net.TCPAddr:
+		lip, lport = a.IP, a.Port
+	default:
+		return false
+	}
+	if lport != port {
+		return false
+	}
+	if len(ip) == 0 || ip.IsUnspecified() {
+		return len(lip) == 0 || lip.IsUnspecified()
+	}
+	return ip.Equal(lip)
+}
+
+func notify(state string) error {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return nil
+	}
+	if strings.HasPrefix(sock, "@") {
+		sock = "\x00" + sock[1:]
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write([]byte(state))
+	return err
+}
+
+func watchdog(ctx context.Context) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return
+	}
+	tick := time.NewTicker(time.Duration(usec) * time.Microsecond / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			notify("WATCHDOG=1")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
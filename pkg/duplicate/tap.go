@@ -0,0 +1,142 @@
+package duplicate
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const DefaultTapCount = 1000
+
+// Tap configures the file where a copy of the first packets is written.
+type Tap struct {
+	File     string   `json:"file,omitempty"`
+	Count    int      `json:"count,omitempty"`
+	Duration Duration `json:"duration,omitempty"`
+}
+
+func (t Tap) isSet() bool {
+	return t.File != ""
+}
+
+// tapper writes a copy of the first packets it sees to a file, as a pcap file
+// when the name of the file ends with .pcap or as an hex dump otherwise, then
+// stops by itself once the number of packets or the duration is reached.
+type tapper struct {
+	file   string
+	pcap   bool
+	clock  clock
+	logger *log.Logger
+
+	mu     sync.Mutex
+	w      *os.File
+	writer *bufio.Writer
+	remain int
+	until  time.Time
+	done   bool
+}
+
+func newTapper(t Tap, clk clock, logger *log.Logger) (*tapper, error) {
+	if !t.isSet() {
+		return nil, nil
+	}
+	f, err := os.Create(t.File)
+	if err != nil {
+		return nil, fmt.Errorf("tap: %w", err)
+	}
+	x := tapper{
+		file:   t.File,
+		pcap:   filepath.Ext(t.File) == ".pcap",
+		clock:  clk,
+		logger: logger,
+		w:      f,
+		writer: bufio.NewWriter(f),
+		remain: t.Count,
+	}
+	if t.Duration.isSet() {
+		x.until = x.clock.Now().Add(t.Duration.In(time.Second))
+		if x.remain <= 0 {
+			x.remain = -1
+		}
+	} else if x.remain <= 0 {
+		x.remain = DefaultTapCount
+	}
+	if x.pcap {
+		pcapHeader(x.writer)
+	}
+	return &x, nil
+}
+
+func (t *tapper) Record(xs []byte, m meta) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return
+	}
+	if !t.until.IsZero() && !m.when.Before(t.until) {
+		t.finish()
+		return
+	}
+	if t.pcap {
+		pcapPacket(t.writer, m, xs)
+	} else {
+		t.dump(xs, m)
+	}
+	if t.remain > 0 {
+		if t.remain--; t.remain == 0 {
+			t.finish()
+		}
+	}
+}
+
+func (t *tapper) dump(xs []byte, m meta) {
+	src := m.source()
+	if src == "" {
+		src = "-"
+	}
+	fmt.Fprintf(t.writer, "%s %s %d bytes\n", m.when.UTC().Format(time.RFC3339Nano), src, len(xs))
+	io.WriteString(t.writer, hex.Dump(xs))
+}
+
+func (t *tapper) finish() {
+	t.done = true
+	err := t.writer.Flush()
+	if e := t.w.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		t.logger.Printf("tap: %s: %s", t.file, err)
+		return
+	}
+	t.logger.Printf("tap: %s: done", t.file)
+}
+
+func (t *tapper) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.done {
+		t.finish()
+	}
+	return nil
+}
+
+type tapWriter struct {
+	io.WriteCloser
+	tap *tapper
+}
+
+func (w tapWriter) Write(xs []byte) (int, error) {
+	w.tap.Record(xs, meta{when: w.tap.clock.Now()})
+	return w.WriteCloser.Write(xs)
+}
+
+func (w tapWriter) Close() error {
+	w.tap.Close()
+	return w.WriteCloser.Close()
+}
@@ -0,0 +1,34 @@
+package duplicate
+
+import (
+	"fmt"
+	"time"
+)
+
+// stamper is implemented by the readers that know the time at which the kernel
+// received the last packet returned by ReadFrom.
+type stamper interface {
+	stamp() time.Time
+}
+
+func received(s stamper, clk clock) time.Time {
+	if s != nil {
+		if t := s.stamp(); !t.IsZero() {
+			return t
+		}
+	}
+	return clk.Now()
+}
+
+func (l Listener) checkTimestamp() error {
+	if !l.Timestamp {
+		return nil
+	}
+	if l.Proto != "" && l.Proto != DefaultProtocol {
+		return fmt.Errorf("timestamp requires an udp listener")
+	}
+	if l.GRO {
+		return fmt.Errorf("timestamp not supported with gro")
+	}
+	return nil
+}
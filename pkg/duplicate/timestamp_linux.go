@@ -0,0 +1,68 @@
+//go:build linux
+
+package duplicate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+type stampReader struct {
+	*net.UDPConn
+	oob  []byte
+	last time.Time
+}
+
+func receiveTimestamps(c *net.UDPConn) (PacketReader, error) {
+	if err := setSockopts(c, []sockopt{setInt(syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)}); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("timestamp: %w", err)
+	}
+	r := stampReader{
+		UDPConn: c,
+		oob:     make([]byte, syscall.CmsgSpace(16)),
+	}
+	return &r, nil
+}
+
+func (r *stampReader) ReadFrom(xs []byte) (int, net.Addr, error) {
+	n, oobn, _, addr, err := r.ReadMsgUDP(xs, r.oob)
+	r.last = time.Time{}
+	if err != nil {
+		return n, addr, err
+	}
+	ms, err := syscall.ParseSocketControlMessage(r.oob[:oobn])
+	if err != nil {
+		return n, addr, nil
+	}
+	for _, m := range ms {
+		if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SCM_TIMESTAMPNS {
+			r.last = timespec(m.Data)
+		}
+	}
+	return n, addr, nil
+}
+
+func (r *stampReader) stamp() time.Time {
+	return r.last
+}
+
+// timespec decodes a struct timespec whose fields are longs.
+func timespec(xs []byte) time.Time {
+	size := strconv.IntSize / 8
+	if len(xs) < 2*size {
+		return time.Time{}
+	}
+	if size == 8 {
+		sec := binary.NativeEndian.Uint64(xs)
+		nsec := binary.NativeEndian.Uint64(xs[8:])
+		return time.Unix(int64(sec), int64(nsec))
+	}
+	sec := binary.NativeEndian.Uint32(xs)
+	nsec := binary.NativeEndian.Uint32(xs[4:])
+	return time.Unix(int64(int32(sec)), int64(int32(nsec)))
+}
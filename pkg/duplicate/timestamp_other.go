@@ -0,0 +1,13 @@
+//go:build !linux
+
+package duplicate
+
+import (
+	"fmt"
+	"net"
+)
+
+func receiveTimestamps(c *net.UDPConn) (PacketReader, error) {
+	c.Close()
+	return nil, fmt.Errorf("timestamp: %w", ErrUnsupported)
+}
@@ -0,0 +1,109 @@
+package duplicate
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+const tlsHandshake = 0x16
+
+// Certificate gives the files of the certificate, key and authority used
+// with tls.
+type Certificate struct {
+	Cert string
+	Key  string
+	CA   string `toml:"ca"`
+}
+
+func (c Certificate) isSet() bool {
+	return c.Cert != "" || c.Key != ""
+}
+
+// Server gives the tls configuration of a listener.
+func (c Certificate) Server() (*tls.Config, error) {
+	if !c.isSet() {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	cfg := tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if c.CA != "" {
+		pool, err := loadPool(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &cfg, nil
+}
+
+// Client gives the tls configuration to dial the given server.
+func (c Certificate) Client(server string) (*tls.Config, error) {
+	cfg := tls.Config{
+		ServerName: server,
+	}
+	if c.isSet() {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if c.CA != "" {
+		pool, err := loadPool(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	return &cfg, nil
+}
+
+func loadPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificate found", file)
+	}
+	return pool, nil
+}
+
+type peekedConn struct {
+	net.Conn
+	rs *bufio.Reader
+}
+
+func (c *peekedConn) Read(xs []byte) (int, error) {
+	return c.rs.Read(xs)
+}
+
+func upgradeConn(c net.Conn, cfg *tls.Config, detect bool) (net.Conn, error) {
+	if cfg == nil {
+		return c, nil
+	}
+	if !detect {
+		return tls.Server(c, cfg), nil
+	}
+	rs := bufio.NewReader(c)
+	b, err := rs.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	pc := peekedConn{Conn: c, rs: rs}
+	if b[0] != tlsHandshake {
+		return &pc, nil
+	}
+	return tls.Server(&pc, cfg), nil
+}
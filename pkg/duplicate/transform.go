@@ -0,0 +1,122 @@
+package duplicate
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type encodeFunc func([]byte) ([]byte, error)
+
+type encoder struct {
+	io.WriteCloser
+	encode encodeFunc
+}
+
+func transformWriter(w io.WriteCloser, transform string, clk clock) (io.WriteCloser, error) {
+	if strings.HasPrefix(transform, execPrefix) {
+		return execWriter(w, transform)
+	}
+	var encode encodeFunc
+	switch transform {
+	case "":
+		return w, nil
+	case "hex":
+		encode = encodeHex
+	case "base64":
+		encode = encodeBase64
+	case "json":
+		encode = encodeJSON(clk)
+	default:
+		return nil, fmt.Errorf("%s: unsupported transform", transform)
+	}
+	return &encoder{WriteCloser: w, encode: encode}, nil
+}
+
+func (e *encoder) Write(xs []byte) (int, error) {
+	buf, err := e.encode(xs)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := e.WriteCloser.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(xs), nil
+}
+
+func encodeHex(xs []byte) ([]byte, error) {
+	buf := make([]byte, hex.EncodedLen(len(xs))+1)
+	hex.Encode(buf, xs)
+	buf[len(buf)-1] = '\n'
+	return buf, nil
+}
+
+func encodeBase64(xs []byte) ([]byte, error) {
+	buf := make([]byte, base64.StdEncoding.EncodedLen(len(xs))+1)
+	base64.StdEncoding.Encode(buf, xs)
+	buf[len(buf)-1] = '\n'
+	return buf, nil
+}
+
+func encodeJSON(clk clock) encodeFunc {
+	return func(xs []byte) ([]byte, error) {
+		c := struct {
+			When    time.Time `json:"time"`
+			Size    int       `json:"size"`
+			Payload []byte    `json:"payload"`
+		}{
+			When:    clk.Now().UTC(),
+			Size:    len(xs),
+			Payload: xs,
+		}
+		buf, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, '\n'), nil
+	}
+}
+
+type rewriter struct {
+	io.WriteCloser
+	strip   int
+	prepend []byte
+	skipped *counter
+	buf     []byte
+}
+
+func rewriteWriter(w io.WriteCloser, strip int, prepend string, skipped *counter) (io.WriteCloser, error) {
+	if strip < 0 {
+		return nil, fmt.Errorf("strip-prefix: invalid number of bytes (%d)", strip)
+	}
+	prefix, err := hex.DecodeString(prepend)
+	if err != nil {
+		return nil, fmt.Errorf("prepend: invalid hex bytes: %w", err)
+	}
+	if strip == 0 && len(prefix) == 0 {
+		return w, nil
+	}
+	r := rewriter{
+		WriteCloser: w,
+		strip:       strip,
+		prepend:     prefix,
+		skipped:     skipped,
+	}
+	return &r, nil
+}
+
+func (r *rewriter) Write(xs []byte) (int, error) {
+	if len(xs) <= r.strip {
+		r.skipped.count(len(xs))
+		return len(xs), nil
+	}
+	r.buf = append(append(r.buf[:0], r.prepend...), xs[r.strip:]...)
+	if _, err := r.WriteCloser.Write(r.buf); err != nil {
+		return 0, err
+	}
+	return len(xs), nil
+}
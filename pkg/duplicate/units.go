@@ -0,0 +1,141 @@
+package duplicate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sizeUnits = map[string]int64{
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+}
+
+// Duration is a duration given with a unit (eg: 250ms) or as a bare number
+// in the unit of the option.
+type Duration struct {
+	value time.Duration
+	bare  bool
+}
+
+// Set parses the duration, so that it can be used as a flag.
+func (d *Duration) Set(str string) error {
+	str = strings.TrimSpace(str)
+	if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+		if n < 0 {
+			return fmt.Errorf("%s: negative duration", str)
+		}
+		*d = Duration{value: time.Duration(n), bare: true}
+		return nil
+	}
+	v, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("%s: invalid duration (eg: 250ms, 2s, 1m30s)", str)
+	}
+	if v < 0 {
+		return fmt.Errorf("%s: negative duration", str)
+	}
+	*d = Duration{value: v}
+	return nil
+}
+
+func (d *Duration) UnmarshalText(xs []byte) error {
+	return d.Set(string(xs))
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d Duration) String() string {
+	if d.bare || d.value == 0 {
+		return strconv.FormatInt(int64(d.value), 10)
+	}
+	return d.value.String()
+}
+
+// In gives the duration, a bare number being read in the given unit.
+func (d Duration) In(unit time.Duration) time.Duration {
+	if d.bare {
+		return d.value * unit
+	}
+	return d.value
+}
+
+func (d Duration) isSet() bool {
+	return d.value > 0
+}
+
+// Size is a size given with a unit (eg: 64MB) or as a bare number in the
+// unit of the option.
+type Size struct {
+	value int64
+	bare  bool
+}
+
+// Set parses the size, so that it can be used as a flag.
+func (s *Size) Set(str string) error {
+	str = strings.TrimSpace(str)
+	if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+		if n < 0 {
+			return fmt.Errorf("%s: negative size", str)
+		}
+		*s = Size{value: n, bare: true}
+		return nil
+	}
+	x := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if x <= 0 {
+		return fmt.Errorf("%s: invalid size (eg: 512KiB, 64MB)", str)
+	}
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(str[x:]))]
+	if !ok {
+		return fmt.Errorf("%s: unknown size unit (available: B, KB, MB, GB, KiB, MiB, GiB)", str)
+	}
+	n, err := strconv.ParseFloat(str[:x], 64)
+	if err != nil || n*float64(unit) > math.MaxInt64 {
+		return fmt.Errorf("%s: invalid size (eg: 512KiB, 64MB)", str)
+	}
+	*s = Size{value: int64(n * float64(unit))}
+	return nil
+}
+
+func (s *Size) UnmarshalText(xs []byte) error {
+	return s.Set(string(xs))
+}
+
+func (s Size) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s Size) String() string {
+	if s.bare || s.value == 0 {
+		return strconv.FormatInt(s.value, 10)
+	}
+	for _, u := range []string{"GiB", "MiB", "KiB"} {
+		if n := sizeUnits[strings.ToLower(u)]; s.value >= n && s.value%n == 0 {
+			return fmt.Sprintf("%d%s", s.value/n, u)
+		}
+	}
+	return fmt.Sprintf("%dB", s.value)
+}
+
+// In gives the size in bytes, a bare number being read in the given unit.
+func (s Size) In(unit int64) int64 {
+	if s.bare {
+		return s.value * unit
+	}
+	return s.value
+}
+
+func (s Size) isSet() bool {
+	return s.value > 0
+}
@@ -0,0 +1,273 @@
+package duplicate
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	wsGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxFrame = 1 << 16
+)
+
+const (
+	wsBinary = 0x2
+	wsClose  = 0x8
+	wsPing   = 0x9
+	wsPong   = 0xA
+)
+
+// ErrHandshake is returned when a websocket server refuses the upgrade.
+var ErrHandshake = errors.New("websocket handshake failed")
+
+type wsconn struct {
+	net.Conn
+
+	mu   sync.Mutex
+	mask [4]byte
+}
+
+func init() {
+	dial := func(r Route) (io.WriteCloser, error) {
+		return dialWebsocket(r)
+	}
+	RegisterSink("ws", dial)
+	RegisterSink("wss", dial)
+}
+
+func dialWebsocket(r Route) (io.WriteCloser, error) {
+	addr := r.Addr
+	if !strings.Contains(addr, "://") {
+		addr = r.Proto + "://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	c, err := dialProxy(u, host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		cfg, err := r.Certificate.Client(u.Hostname())
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		t := tls.Client(c, cfg)
+		if err := t.Handshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c = t
+	}
+	rs, err := handshake(c, u)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	ws := wsconn{Conn: c}
+	go ws.discard(rs)
+	return &ws, nil
+}
+
+func dialProxy(u *url.URL, host string) (net.Conn, error) {
+	scheme := "http"
+	if u.Scheme == "wss" {
+		scheme = "https"
+	}
+	req := http.Request{URL: &url.URL{Scheme: scheme, Host: host}}
+	proxy, err := http.ProxyFromEnvironment(&req)
+	if err != nil || proxy == nil {
+		return net.Dial("tcp", host)
+	}
+	c, err := net.Dial("tcp", proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+	connect := http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: host},
+		Host:   host,
+		Header: make(http.Header),
+	}
+	if p := proxy.User; p != nil {
+		pass, _ := p.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(p.Username() + ":" + pass))
+		connect.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := connect.Write(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	rs, err := http.ReadResponse(bufio.NewReader(c), &connect)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		c.Close()
+		return nil, fmt.Errorf("proxy %s: %s", proxy.Host, rs.Status)
+	}
+	return c, nil
+}
+
+func handshake(c net.Conn, u *url.URL) (*bufio.Reader, error) {
+	var key [16]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, err
+	}
+	nonce := base64.StdEncoding.EncodeToString(key[:])
+
+	req := http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Host:   u.Host,
+		Header: make(http.Header),
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", nonce)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+
+	rs := bufio.NewReader(c)
+	res, err := http.ReadResponse(rs, &req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: %s", ErrHandshake, res.Status)
+	}
+	sum := sha1.Sum([]byte(nonce + wsGUID))
+	if res.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, fmt.Errorf("%w: invalid accept key", ErrHandshake)
+	}
+	return rs, nil
+}
+
+func (w *wsconn) Write(xs []byte) (int, error) {
+	if err := w.writeFrame(wsBinary, xs); err != nil {
+		return 0, err
+	}
+	return len(xs), nil
+}
+
+func (w *wsconn) Close() error {
+	w.writeFrame(wsClose, nil)
+	return w.Conn.Close()
+}
+
+func (w *wsconn) writeFrame(op byte, xs []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var (
+		size  = len(xs)
+		frame = make([]byte, 0, size+14)
+	)
+	frame = append(frame, 0x80|op)
+	switch {
+	case size < 126:
+		frame = append(frame, 0x80|byte(size))
+	case size <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(size))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(size))
+	}
+	if _, err := rand.Read(w.mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, w.mask[:]...)
+	for i, b := range xs {
+		frame = append(frame, b^w.mask[i%4])
+	}
+	_, err := w.Conn.Write(frame)
+	return err
+}
+
+func (w *wsconn) discard(rs *bufio.Reader) {
+	for {
+		op, body, err := readFrame(rs)
+		if err != nil {
+			return
+		}
+		switch op {
+		case wsPing:
+			w.writeFrame(wsPong, body)
+		case wsClose:
+			w.Conn.Close()
+			return
+		}
+	}
+}
+
+func readFrame(rs *bufio.Reader) (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(rs, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	size := uint64(hdr[1] & 0x7F)
+	switch size {
+	case 126:
+		var n [2]byte
+		if _, err := io.ReadFull(rs, n[:]); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(n[:]))
+	case 127:
+		var n [8]byte
+		if _, err := io.ReadFull(rs, n[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(n[:])
+	}
+	var mask [4]byte
+	if hdr[1]&0x80 != 0 {
+		if _, err := io.ReadFull(rs, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	if size > wsMaxFrame {
+		return 0, nil, fmt.Errorf("websocket: frame too large (%d bytes)", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(rs, body); err != nil {
+		return 0, nil, err
+	}
+	if hdr[1]&0x80 != 0 {
+		for i := range body {
+			body[i] ^= mask[i%4]
+		}
+	}
+	return hdr[0] & 0x0F, body, nil
+}
@@ -0,0 +1,154 @@
+package duplicate
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	maxWorkers    = 64
+	workerBacklog = 64
+)
+
+func (r Route) checkWorkers() error {
+	if r.Workers < 0 || r.Workers > maxWorkers {
+		return fmt.Errorf("workers: number of workers should be between 1 and %d", maxWorkers)
+	}
+	if r.Workers <= 1 {
+		if r.Ordered {
+			return fmt.Errorf("ordered: requires several workers")
+		}
+		return nil
+	}
+	if r.Listen || r.Share || len(r.Members) > 0 || r.Annotate || r.Envelope != "" || r.Heartbeat.isSet() || r.LatencyProbe.isSet() || r.MTU > 0 {
+		return fmt.Errorf("workers: not supported with listen, share, groups, annotate, envelope, heartbeat, latency-probe and mtu")
+	}
+	return nil
+}
+
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var err error
+	for _, c := range cs {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func duplicateWorkers(ctx context.Context, rt *route, r io.ReadCloser, logger *log.Logger) (func() error, error) {
+	var (
+		ws []io.WriteCloser
+		cs closers
+	)
+	for i := 0; i < rt.Workers; i++ {
+		w, c, err := open(rt.Route, rt.clock, logger)
+		if err != nil {
+			for _, w := range ws {
+				w.Close()
+			}
+			return nil, err
+		}
+		if rt.Simulate.isSet() {
+			w = simulateWriter(w, rt.Simulate, rt.clock, logger)
+		}
+		ws = append(ws, w)
+		cs = append(cs, c)
+	}
+	rt.conn = cs
+	if rt.Tap.isSet() {
+		t, err := newTapper(rt.Tap, rt.clock, logger)
+		if err != nil {
+			for _, w := range ws {
+				w.Close()
+			}
+			return nil, err
+		}
+		for i := range ws {
+			ws[i] = tapWriter{WriteCloser: ws[i], tap: t}
+		}
+	}
+
+	queues := make([]chan *[]byte, len(ws))
+	if rt.Ordered {
+		for i := range queues {
+			queues[i] = make(chan *[]byte, workerBacklog)
+		}
+	} else {
+		q := make(chan *[]byte, workerBacklog*len(ws))
+		for i := range queues {
+			queues[i] = q
+		}
+	}
+	fn := func() error {
+		var grp sync.WaitGroup
+		for i, w := range ws {
+			grp.Add(1)
+			go func(w io.WriteCloser, q chan *[]byte) {
+				defer grp.Done()
+				defer w.Close()
+				for buf := range q {
+					if _, err := w.Write(*buf); errors.Is(err, ErrNotConnected) {
+						rt.dropped.count(len(*buf))
+					} else if err != nil {
+						atomic.AddUint64(&rt.errors, 1)
+					} else {
+						rt.sent.count(len(*buf))
+					}
+					putPacket(buf)
+				}
+			}(w, queues[i])
+		}
+		defer func() {
+			r.Close()
+			if rt.Ordered {
+				for _, q := range queues {
+					close(q)
+				}
+			} else {
+				close(queues[0])
+			}
+			grp.Wait()
+		}()
+		go func() {
+			<-ctx.Done()
+			rt.Abort()
+		}()
+		xs := make([]byte, MaxPacketSize)
+		for {
+			n, err := r.Read(xs)
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+				break
+			}
+			if err != nil {
+				continue
+			}
+			if !rt.wait(ctx) {
+				rt.dropped.count(n)
+				continue
+			}
+			buf := getPacket(n)
+			copy(*buf, xs[:n])
+			q := queues[0]
+			if rt.Ordered && n >= ccsdsHeaderLen {
+				apid := binary.BigEndian.Uint16(xs) & 0x07ff
+				q = queues[int(apid)%len(queues)]
+			}
+			select {
+			case q <- buf:
+			case <-ctx.Done():
+				putPacket(buf)
+			}
+		}
+		return nil
+	}
+	return fn, nil
+}
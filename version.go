@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/busoc/duplicate/pkg/duplicate"
+)
+
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+func runVersion(args []string) error {
+	commit, date := Commit, BuildDate
+	if i, ok := debug.ReadBuildInfo(); ok {
+		var modified bool
+		for _, s := range i.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if commit == "" {
+					commit = s.Value
+				}
+			case "vcs.time":
+				if date == "" {
+					date = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && Commit == "" && commit != "" {
+			commit += " (modified)"
+		}
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	fmt.Printf("duplicate %s\n", Version)
+	fmt.Printf("commit:   %s\n", commit)
+	fmt.Printf("built:    %s\n", date)
+	fmt.Printf("go:       %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("features: %s\n", strings.Join(features(), ", "))
+	return nil
+}
+
+func features() []string {
+	fs := []string{"tls", "pcap", "raw", "archive", "admin"}
+	for _, s := range duplicate.Sinks() {
+		fs = append(fs, "sink:"+s)
+	}
+	return fs
+}